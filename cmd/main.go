@@ -5,43 +5,158 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/sirupsen/logrus"
 
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/forwarder"
 	"github.com/lily0ng/RootProxy/internal/rootproxy"
+	"github.com/lily0ng/RootProxy/internal/state"
 	"github.com/lily0ng/RootProxy/internal/tui"
 	"github.com/lily0ng/RootProxy/pkg/api"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		tokenCmd(os.Args[2:])
+		return
+	}
+
 	var (
-		profile  = flag.String("profile", "", "profile name")
-		apiAddr  = flag.String("api", "", "start REST API server on address (e.g. 127.0.0.1:8081)")
-		headless = flag.Bool("headless", false, "run without TUI (API-only mode)")
+		profile   = flag.String("profile", "", "profile name")
+		configDir = flag.String("config-dir", "", "state directory (default $XDG_CONFIG_HOME/rootproxy)")
+		apiAddr   = flag.String("api", "", "start REST API server on address (e.g. 127.0.0.1:8081, or unix:///path/rootproxy.sock)")
+		apiMode   = flag.String("api-socket-mode", "0600", "file mode of the API unix socket")
+		apiTLS    = flag.Bool("api-tls", false, "serve the API over HTTPS (self-signed certificate generated on first run)")
+		apiCert   = flag.String("api-cert", "", "certificate the API serves with --api-tls (default \"api\")")
+		clientCA  = flag.String("api-client-ca", "", "require API clients to present a certificate signed by this CA certificate")
+		listen    = flag.String("listen", "", "start local HTTP proxy listener on address (e.g. 127.0.0.1:8118)")
+		socksAddr = flag.String("socks", "", "start local SOCKS5 listener on address (e.g. 127.0.0.1:1080)")
+		socksUser = flag.String("socks-user", "", "require SOCKS5 username/password auth with this username")
+		socksPass = flag.String("socks-pass", "", "password for --socks-user")
+		upstream  = flag.String("upstream", "", "listener upstream: active, profile, sticky, rotate or chain:<name>")
+		probe     = flag.String("probe-target", "", "host:port proxy tests CONNECT to (default: handshake only)")
+		echoURL   = flag.String("echo-url", "", "echo endpoint used to detect exit IP and anonymity (default http://httpbin.org/get)")
+		vaultPass = flag.String("vault-passphrase-file", "", "unlock the credential vault at startup with the passphrase in this file")
+		health    = flag.Duration("health-interval", 0, "test every proxy on this interval (overrides saved settings)")
+		headless  = flag.Bool("headless", false, "run without TUI (API-only mode)")
 	)
 	flag.Parse()
 
 	logrus.SetOutput(os.Stdout)
 	logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
 
-	app := rootproxy.NewApp()
+	dir := *configDir
+	if dir == "" {
+		d, err := state.DefaultDir()
+		if err != nil {
+			logrus.WithError(err).Fatal("cannot determine config directory; use --config-dir")
+		}
+		dir = d
+	}
+	app, err := rootproxy.Open(dir)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to load state")
+	}
 	if *profile != "" {
 		_ = app.Profiles.SetActive(*profile)
 	}
 
+	if *vaultPass != "" {
+		b, err := os.ReadFile(*vaultPass)
+		if err != nil {
+			logrus.WithError(err).Fatal("cannot read --vault-passphrase-file")
+		}
+		if err := app.UnlockVault(strings.TrimRight(string(b), "\r\n")); err != nil {
+			logrus.WithError(err).Fatal("cannot unlock vault")
+		}
+	}
+
+	if *probe != "" {
+		app.Settings.ProbeTarget = *probe
+	}
+	if *echoURL != "" {
+		app.Settings.EchoURL = *echoURL
+	}
+	if *health > 0 {
+		cfg := app.Health.Config()
+		cfg.Interval = *health
+		if err := app.Health.Configure(cfg); err != nil {
+			logrus.WithError(err).Fatal("invalid --health-interval")
+		}
+	}
+
+	ls := &app.Settings.Listeners
+	if *listen != "" {
+		ls.HTTPAddr = *listen
+	}
+	if *socksAddr != "" {
+		ls.SOCKSAddr = *socksAddr
+	}
+	if *socksUser != "" {
+		ls.SOCKSUser, ls.SOCKSPass = *socksUser, *socksPass
+	}
+	if *upstream != "" {
+		mode, chain, err := config.ParseUpstream(*upstream)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid --upstream")
+		}
+		ls.Upstream, ls.UpstreamChain = mode, chain
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if ls.HTTPAddr != "" {
+		if err := app.Listeners.Start(forwarder.KindHTTP, ls.HTTPAddr, forwarder.Options{}); err != nil {
+			logrus.WithError(err).Fatal("http proxy listener failed")
+		}
+	}
+	if ls.SOCKSAddr != "" {
+		user, pass, err := app.SOCKSCredentials()
+		if err != nil {
+			logrus.WithError(err).Fatal("socks5 listener failed; unlock the vault with --vault-passphrase-file")
+		}
+		opts := forwarder.Options{User: user, Pass: pass}
+		if err := app.Listeners.Start(forwarder.KindSOCKS5, ls.SOCKSAddr, opts); err != nil {
+			logrus.WithError(err).Fatal("socks5 listener failed")
+		}
+	}
+	defer app.Close()
+
 	var srv *api.Server
 	if *apiAddr != "" {
-		srv = api.NewServer(*apiAddr, app)
+		var opts api.Options
+		mode, err := strconv.ParseUint(*apiMode, 8, 32)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid --api-socket-mode")
+		}
+		opts.SocketMode = os.FileMode(mode)
+		if *apiTLS {
+			opts.TLS, err = app.APITLSConfig(*apiCert, *clientCA, *apiAddr)
+			if err != nil {
+				logrus.WithError(err).Fatal("api tls")
+			}
+		} else if *clientCA != "" || *apiCert != "" {
+			logrus.Fatal("--api-cert and --api-client-ca require --api-tls")
+		}
+		srv, err = api.NewServer(*apiAddr, app, opts)
+		if err != nil {
+			logrus.WithError(err).Fatal("api server")
+		}
 	}
 
 	if *headless {
+		if srv == nil && len(app.Listeners.List()) == 0 {
+			logrus.Fatal("headless mode requires --api <addr>, --listen <addr> or --socks <addr>")
+		}
 		if srv == nil {
-			logrus.Fatal("headless mode requires --api <addr>")
+			<-ctx.Done()
+			return
 		}
 		if err := srv.Start(ctx); err != nil {
 			logrus.WithError(err).Fatal("api server stopped")
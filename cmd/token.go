@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lily0ng/RootProxy/internal/auth"
+	"github.com/lily0ng/RootProxy/internal/state"
+)
+
+const tokenUsage = `usage:
+  rootproxy token create -name <name> -scopes <scope,...> [-config-dir <dir>]
+  rootproxy token list [-config-dir <dir>]
+  rootproxy token revoke [-config-dir <dir>] <id>
+
+scopes: read, proxy:write, security:write, cert:write`
+
+// tokenCmd manages API tokens. It works on the token file directly, so it
+// can be used while RootProxy is running.
+func tokenCmd(args []string) {
+	if len(args) == 0 {
+		fail(tokenUsage)
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	configDir := fs.String("config-dir", "", "state directory (default $XDG_CONFIG_HOME/rootproxy)")
+	name := fs.String("name", "", "token name")
+	scopes := fs.String("scopes", string(auth.ScopeRead), "comma-separated scopes")
+	_ = fs.Parse(args[1:])
+
+	dir := *configDir
+	if dir == "" {
+		d, err := state.DefaultDir()
+		if err != nil {
+			fail("cannot determine config directory; use -config-dir")
+		}
+		dir = d
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		fail(err.Error())
+	}
+	store, err := auth.Open(filepath.Join(dir, auth.FileName))
+	if err != nil {
+		fail(err.Error())
+	}
+
+	switch args[0] {
+	case "create":
+		sc, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			fail(err.Error())
+		}
+		t, secret, err := store.Create(*name, sc)
+		if err != nil {
+			fail(err.Error())
+		}
+		fmt.Printf("created token %s (%s) with scopes %s\n", t.ID, t.Name, joinScopes(t.Scopes))
+		fmt.Println("store this secret now, it cannot be shown again:")
+		fmt.Println(secret)
+	case "list":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED")
+		for _, t := range store.List() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.ID, t.Name, joinScopes(t.Scopes), t.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		_ = tw.Flush()
+	case "revoke":
+		if fs.NArg() != 1 {
+			fail(tokenUsage)
+		}
+		if err := store.Revoke(fs.Arg(0)); err != nil {
+			fail(err.Error())
+		}
+		fmt.Printf("revoked token %s\n", fs.Arg(0))
+	default:
+		fail(tokenUsage)
+	}
+}
+
+func joinScopes(scopes []auth.Scope) string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return strings.Join(out, ",")
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(2)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/state"
+)
+
+type Scope string
+
+const (
+	ScopeRead          Scope = "read"
+	ScopeProxyWrite    Scope = "proxy:write"
+	ScopeSecurityWrite Scope = "security:write"
+	ScopeCertWrite     Scope = "cert:write"
+)
+
+var Scopes = []Scope{ScopeRead, ScopeProxyWrite, ScopeSecurityWrite, ScopeCertWrite}
+
+// idSource supplies the random bytes of token IDs.
+var idSource io.Reader = rand.Reader
+
+// ParseScopes reads a comma-separated scope list.
+func ParseScopes(s string) ([]Scope, error) {
+	var out []Scope
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !validScope(Scope(part)) {
+			return nil, fmt.Errorf("unknown scope: %s", part)
+		}
+		out = append(out, Scope(part))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("at least one scope required")
+	}
+	return out, nil
+}
+
+func validScope(s Scope) bool {
+	for _, v := range Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// tokenPrefix marks RootProxy API tokens so they are easy to recognize.
+const tokenPrefix = "rp_"
+
+// Token is an API token as stored: only the SHA-256 of the secret is kept.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (t Token) Has(s Scope) bool {
+	for _, v := range t.Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FileName is where Open keeps tokens, next to the state file.
+const FileName = "tokens.json"
+
+// Store keeps tokens in their own file rather than the state file, so the
+// CLI can create and revoke tokens while RootProxy runs; a running store
+// rereads the file whenever it changes.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	byID    map[string]Token
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the tokens at path. An empty path keeps them in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, byID: make(map[string]Token)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload rereads the file if it changed since it was last read or written.
+// Callers hold s.mu.
+func (s *Store) reload() error {
+	if s.path == "" {
+		return nil
+	}
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.byID = make(map[string]Token)
+		s.modTime, s.size = time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return fmt.Errorf("%s: %w", FileName, err)
+	}
+	s.byID = make(map[string]Token, len(tokens))
+	for _, t := range tokens {
+		s.byID[t.ID] = t
+	}
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// save writes every token back. Callers hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := state.WriteFileAtomic(s.path, b, 0o600); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// Create generates a token and returns it with its secret, which is not
+// stored and cannot be shown again.
+func (s *Store) Create(name string, scopes []Scope) (Token, string, error) {
+	if name == "" {
+		return Token{}, "", errors.New("token name required")
+	}
+	if len(scopes) == 0 {
+		return Token{}, "", errors.New("at least one scope required")
+	}
+	for _, sc := range scopes {
+		if !validScope(sc) {
+			return Token{}, "", fmt.Errorf("unknown scope: %s", sc)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Token{}, "", err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t := Token{
+		Name:      name,
+		Hash:      hashSecret(secret),
+		Scopes:    append([]Scope(nil), scopes...),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return Token{}, "", err
+	}
+	// IDs are short, so draw again rather than replace a token in use
+	id := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(idSource, id); err != nil {
+			return Token{}, "", err
+		}
+		t.ID = hex.EncodeToString(id)
+		if _, taken := s.byID[t.ID]; !taken {
+			break
+		}
+	}
+	s.byID[t.ID] = t
+	if err := s.save(); err != nil {
+		delete(s.byID, t.ID)
+		return Token{}, "", err
+	}
+	return t, secret, nil
+}
+
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	t, ok := s.byID[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(s.byID, id)
+	if err := s.save(); err != nil {
+		s.byID[id] = t
+		return err
+	}
+	return nil
+}
+
+func (s *Store) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.reload()
+	return s.list()
+}
+
+func (s *Store) list() []Token {
+	out := make([]Token, 0, len(s.byID))
+	for _, t := range s.byID {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Enabled reports whether any token exists; without tokens the API is open.
+func (s *Store) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.reload()
+	return len(s.byID) > 0
+}
+
+// Authenticate finds the token whose secret is given.
+func (s *Store) Authenticate(secret string) (Token, bool) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return Token{}, false
+	}
+	h := hashSecret(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.reload()
+	for _, t := range s.byID {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(h)) == 1 {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// hashSecret uses a plain SHA-256: secrets are 256 random bits, so there is
+// nothing for a slow password hash to protect.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
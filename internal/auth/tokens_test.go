@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled() {
+		t.Fatal("empty store enabled")
+	}
+	tok, secret, err := s.Create("ci", []Scope{ScopeRead, ScopeProxyWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled() {
+		t.Error("store with a token not enabled")
+	}
+	got, ok := s.Authenticate(secret)
+	if !ok || got.ID != tok.ID {
+		t.Fatalf("authenticate = %+v, %v", got, ok)
+	}
+	if !got.Has(ScopeProxyWrite) || got.Has(ScopeSecurityWrite) {
+		t.Errorf("scopes = %v", got.Scopes)
+	}
+	for _, bad := range []string{"", secret[len(tokenPrefix):], secret + "x", tok.Hash} {
+		if _, ok := s.Authenticate(bad); ok {
+			t.Errorf("authenticated with %q", bad)
+		}
+	}
+
+	if _, _, err := s.Create("bad", []Scope{"admin"}); err == nil {
+		t.Error("unknown scope accepted")
+	}
+	if err := s.Revoke("nope"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("revoke unknown = %v", err)
+	}
+	if err := s.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Authenticate(secret); ok {
+		t.Error("revoked token authenticated")
+	}
+	if s.Enabled() {
+		t.Error("store enabled after revoking the last token")
+	}
+}
+
+func TestCreateRedrawsTakenID(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r io.Reader) { idSource = r }(idSource)
+	idSource = bytes.NewReader([]byte{1, 2, 3, 4, 1, 2, 3, 4, 5, 6, 7, 8})
+
+	first, firstSecret, err := s.Create("one", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.Create("two", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != "01020304" || second.ID != "05060708" {
+		t.Errorf("ids = %s, %s", first.ID, second.ID)
+	}
+	if got, ok := s.Authenticate(firstSecret); !ok || got.Name != "one" {
+		t.Errorf("first token replaced: %+v, %v", got, ok)
+	}
+}
+
+func TestRunningStoreSeesOtherProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	running, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.Enabled() {
+		t.Fatal("new store enabled")
+	}
+
+	// the CLI opens its own store on the same file
+	cli, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, secret, err := cli.Create("ci", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := running.Authenticate(secret); !ok {
+		t.Fatal("token created by the CLI not picked up")
+	}
+
+	other, _, err := running.Create("tui", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cli.List()); n != 2 {
+		t.Errorf("CLI lists %d tokens, want 2", n)
+	}
+
+	if err := cli.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := running.Authenticate(secret); ok {
+		t.Error("token revoked by the CLI still accepted")
+	}
+	if err := cli.Revoke(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if running.Enabled() {
+		t.Error("running store enabled after the CLI revoked every token")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	got, err := ParseScopes(" read, cert:write ,")
+	if err != nil || len(got) != 2 || got[0] != ScopeRead || got[1] != ScopeCertWrite {
+		t.Errorf("ParseScopes = %v, %v", got, err)
+	}
+	for _, bad := range []string{"", ",", "read,root"} {
+		if _, err := ParseScopes(bad); err == nil {
+			t.Errorf("ParseScopes(%q) accepted", bad)
+		}
+	}
+}
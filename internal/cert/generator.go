@@ -7,12 +7,15 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
+	"net"
 	"time"
 )
 
 type SelfSignedOptions struct {
 	CommonName string
 	ValidFor   time.Duration
+	// Hosts are DNS names or IP addresses the certificate is valid for.
+	Hosts []string
 }
 
 type Generated struct {
@@ -45,6 +48,13 @@ func GenerateSelfSigned(opts SelfSignedOptions) (Generated, error) {
 		IsCA:                  true,
 		BasicConstraintsValid: true,
 	}
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
 
 	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
 	if err != nil {
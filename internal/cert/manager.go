@@ -4,17 +4,21 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"sort"
 	"sync"
 )
 
 type Certificate struct {
 	Name string
 	PEM  []byte
+	// KeyRef names the private key in the credential vault, if it is held.
+	KeyRef string
 }
 
 type Manager struct {
-	mu     sync.RWMutex
-	byName map[string]Certificate
+	mu       sync.RWMutex
+	byName   map[string]Certificate
+	onChange func()
 }
 
 func NewManager() *Manager {
@@ -33,11 +37,45 @@ func (m *Manager) Add(name string, pemBytes []byte) error {
 	}
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.byName[name] = Certificate{Name: name, PEM: pemBytes}
+	fn := m.onChange
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+	return nil
+}
+
+func (m *Manager) SetKeyRef(name, ref string) error {
+	m.mu.Lock()
+	c, ok := m.byName[name]
+	if !ok {
+		m.mu.Unlock()
+		return errors.New("certificate not found")
+	}
+	c.KeyRef = ref
+	m.byName[name] = c
+	fn := m.onChange
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
 	return nil
 }
 
+func (m *Manager) Get(name string) (Certificate, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.byName[name]
+	return c, ok
+}
+
+func (m *Manager) SetOnChange(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = fn
+}
+
 func (m *Manager) List() []Certificate {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -45,6 +83,7 @@ func (m *Manager) List() []Certificate {
 	for _, c := range m.byName {
 		out = append(out, c)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out
 }
 
@@ -10,6 +10,7 @@ import (
 type Profile struct {
 	Name      string
 	Chain     []string
+	Rotation  RotationPolicy
 	UpdatedAt time.Time
 }
 
@@ -17,6 +18,7 @@ type ProfileStore struct {
 	mu         sync.RWMutex
 	activeName string
 	byName     map[string]Profile
+	onChange   func()
 }
 
 func NewProfileStore(defaultActive string) *ProfileStore {
@@ -26,20 +28,39 @@ func NewProfileStore(defaultActive string) *ProfileStore {
 	}
 }
 
+func (s *ProfileStore) SetOnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+func (s *ProfileStore) changed() {
+	s.mu.RLock()
+	fn := s.onChange
+	s.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (s *ProfileStore) Upsert(p Profile) error {
 	if p.Name == "" {
 		return errors.New("profile name required")
 	}
+	if err := p.Rotation.validate(); err != nil {
+		return err
+	}
 	if p.UpdatedAt.IsZero() {
 		p.UpdatedAt = time.Now().UTC()
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.byName[p.Name] = p
 	if s.activeName == "" {
 		s.activeName = p.Name
 	}
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
 
@@ -54,6 +75,13 @@ func (s *ProfileStore) List() []Profile {
 	return out
 }
 
+func (s *ProfileStore) Get(name string) (Profile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.byName[name]
+	return p, ok
+}
+
 func (s *ProfileStore) Active() string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -61,14 +89,16 @@ func (s *ProfileStore) Active() string {
 }
 
 func (s *ProfileStore) SetActive(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	if name == "" {
 		return errors.New("profile name required")
 	}
+	s.mu.Lock()
 	if _, ok := s.byName[name]; !ok {
+		s.mu.Unlock()
 		return errors.New("profile not found")
 	}
 	s.activeName = name
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
@@ -1,6 +1,11 @@
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
 
 type RotationMode string
 
@@ -8,10 +13,158 @@ const (
 	RotationOff        RotationMode = "off"
 	RotationRoundRobin RotationMode = "round_robin"
 	RotationRandom     RotationMode = "random"
+	// RotationHealthy is round robin over proxies that pass the health check.
+	RotationHealthy RotationMode = "healthy"
+	// RotationWeighted picks randomly in proportion to each proxy's Weight.
+	RotationWeighted      RotationMode = "weighted"
+	RotationLowestLatency RotationMode = "lowest_latency"
+	RotationLeastFailures RotationMode = "least_failures"
 )
 
+// MinRotationInterval keeps scheduled rotation from spinning.
+const MinRotationInterval = time.Second
+
 type RotationPolicy struct {
 	Enabled  bool
 	Mode     RotationMode
 	Interval time.Duration
+	// SkipUnhealthy applies the health check of RotationHealthy to the
+	// other modes.
+	SkipUnhealthy bool
+	// MaxFailures is how many consecutive failed tests make a proxy
+	// unhealthy (default 1).
+	MaxFailures int
+	// MaxTestAge treats proxies not tested within it as unhealthy; zero
+	// accepts results of any age.
+	MaxTestAge time.Duration
+	// Retest tests the chosen proxy before switching to it.
+	Retest bool
+	// StickyTTL is how long an idle target host keeps its upstream under
+	// the sticky listener upstream.
+	StickyTTL time.Duration
+}
+
+// Scheduled reports whether the policy asks for time-based rotation.
+func (p RotationPolicy) Scheduled() bool {
+	return p.Enabled && p.Mode != "" && p.Mode != RotationOff && p.Interval > 0
+}
+
+// HealthChecked reports whether rotation must skip unhealthy proxies.
+func (p RotationPolicy) HealthChecked() bool {
+	return p.Mode == RotationHealthy || p.SkipUnhealthy
+}
+
+func (p RotationPolicy) validate() error {
+	switch p.Mode {
+	case "", RotationOff, RotationRoundRobin, RotationRandom, RotationHealthy,
+		RotationWeighted, RotationLowestLatency, RotationLeastFailures:
+	default:
+		return fmt.Errorf("unsupported rotation mode: %s", p.Mode)
+	}
+	if p.Interval < 0 {
+		return errors.New("rotation interval must not be negative")
+	}
+	if p.Interval > 0 && p.Interval < MinRotationInterval {
+		return fmt.Errorf("rotation interval must be at least %s", MinRotationInterval)
+	}
+	if p.MaxFailures < 0 {
+		return errors.New("rotation max failures must not be negative")
+	}
+	if p.MaxTestAge < 0 {
+		return errors.New("rotation max test age must not be negative")
+	}
+	if p.StickyTTL < 0 {
+		return errors.New("rotation sticky ttl must not be negative")
+	}
+	return nil
+}
+
+type rotationPolicyJSON struct {
+	Enabled       bool
+	Mode          RotationMode
+	Interval      string `json:",omitempty"`
+	SkipUnhealthy bool   `json:",omitempty"`
+	MaxFailures   int    `json:",omitempty"`
+	MaxTestAge    string `json:",omitempty"`
+	Retest        bool   `json:",omitempty"`
+	StickyTTL     string `json:",omitempty"`
+}
+
+// Durations are written as strings ("30s", "5m") rather than nanoseconds;
+// plain numbers are still accepted as seconds.
+func (p RotationPolicy) MarshalJSON() ([]byte, error) {
+	v := rotationPolicyJSON{
+		Enabled:       p.Enabled,
+		Mode:          p.Mode,
+		SkipUnhealthy: p.SkipUnhealthy,
+		MaxFailures:   p.MaxFailures,
+		Retest:        p.Retest,
+	}
+	if p.Interval > 0 {
+		v.Interval = p.Interval.String()
+	}
+	if p.MaxTestAge > 0 {
+		v.MaxTestAge = p.MaxTestAge.String()
+	}
+	if p.StickyTTL > 0 {
+		v.StickyTTL = p.StickyTTL.String()
+	}
+	return json.Marshal(v)
+}
+
+func (p *RotationPolicy) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Enabled       bool
+		Mode          RotationMode
+		Interval      json.RawMessage
+		SkipUnhealthy bool
+		MaxFailures   int
+		MaxTestAge    json.RawMessage
+		Retest        bool
+		StickyTTL     json.RawMessage
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	interval, err := parseJSONDuration(v.Interval)
+	if err != nil {
+		return fmt.Errorf("rotation interval: %w", err)
+	}
+	maxAge, err := parseJSONDuration(v.MaxTestAge)
+	if err != nil {
+		return fmt.Errorf("rotation max test age: %w", err)
+	}
+	stickyTTL, err := parseJSONDuration(v.StickyTTL)
+	if err != nil {
+		return fmt.Errorf("rotation sticky ttl: %w", err)
+	}
+	*p = RotationPolicy{
+		Enabled:       v.Enabled,
+		Mode:          v.Mode,
+		Interval:      interval,
+		SkipUnhealthy: v.SkipUnhealthy,
+		MaxFailures:   v.MaxFailures,
+		MaxTestAge:    maxAge,
+		Retest:        v.Retest,
+		StickyTTL:     stickyTTL,
+	}
+	return nil
+}
+
+func parseJSONDuration(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if s == "" {
+			return 0, nil
+		}
+		return time.ParseDuration(s)
+	}
+	var secs float64
+	if err := json.Unmarshal(raw, &secs); err != nil {
+		return 0, errors.New("must be a duration string or seconds")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
 }
@@ -2,7 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"path"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -37,14 +41,30 @@ type RoutingRule struct {
 }
 
 type RoutingStore struct {
-	mu   sync.RWMutex
-	byID map[string]RoutingRule
+	mu       sync.RWMutex
+	byID     map[string]RoutingRule
+	onChange func()
 }
 
 func NewRoutingStore() *RoutingStore {
 	return &RoutingStore{byID: make(map[string]RoutingRule)}
 }
 
+func (s *RoutingStore) SetOnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+func (s *RoutingStore) changed() {
+	s.mu.RLock()
+	fn := s.onChange
+	s.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (s *RoutingStore) Upsert(r RoutingRule) error {
 	if r.ID == "" {
 		r.ID = NewID()
@@ -61,26 +81,32 @@ func (s *RoutingStore) Upsert(r RoutingRule) error {
 	if r.Action == "" {
 		return errors.New("routing rule action required")
 	}
+	if err := r.validate(); err != nil {
+		return err
+	}
 	if r.UpdatedAt.IsZero() {
 		r.UpdatedAt = time.Now().UTC()
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.byID[r.ID] = r
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
 
 func (s *RoutingStore) Remove(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	if id == "" {
 		return errors.New("routing rule id required")
 	}
+	s.mu.Lock()
 	if _, ok := s.byID[id]; !ok {
+		s.mu.Unlock()
 		return errors.New("routing rule not found")
 	}
 	delete(s.byID, id)
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
 
@@ -99,3 +125,126 @@ func (s *RoutingStore) List() []RoutingRule {
 	})
 	return out
 }
+
+func (r RoutingRule) validate() error {
+	switch r.Match {
+	case MatchDomainGlob:
+		if _, err := path.Match(globPath(r.Pattern), ""); err != nil {
+			return fmt.Errorf("invalid domain glob: %s", r.Pattern)
+		}
+	case MatchDomainSuffix:
+	case MatchCIDR:
+		if _, err := parseCIDR(r.Pattern); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported routing match: %s", r.Match)
+	}
+
+	switch r.Action {
+	case RouteDirect, RouteProxy, RouteProfile:
+	case RouteChain:
+		if r.Target == "" {
+			return errors.New("routing rule target chain required")
+		}
+	default:
+		return fmt.Errorf("unsupported routing action: %s", r.Action)
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to a destination. CIDR rules only
+// see ip (or a literal IP host); host names are never resolved here so that
+// routing does not leak DNS lookups outside the proxy.
+func (r RoutingRule) Matches(host string, ip net.IP) (bool, error) {
+	host = normalizeHost(host)
+	switch r.Match {
+	case MatchDomainGlob:
+		if host == "" {
+			return false, nil
+		}
+		// '*' and '?' never cross a label boundary: "*.example.com"
+		// matches "a.example.com" but not "example.com" or "a.b.example.com"
+		ok, err := path.Match(globPath(r.Pattern), globPath(host))
+		if err != nil {
+			return false, fmt.Errorf("invalid domain glob: %s", r.Pattern)
+		}
+		return ok, nil
+	case MatchDomainSuffix:
+		suffix := normalizeHost(strings.TrimPrefix(r.Pattern, "."))
+		if host == "" || suffix == "" {
+			return false, nil
+		}
+		return host == suffix || strings.HasSuffix(host, "."+suffix), nil
+	case MatchCIDR:
+		if ip == nil {
+			ip = net.ParseIP(host)
+		}
+		if ip == nil {
+			return false, nil
+		}
+		n, err := parseCIDR(r.Pattern)
+		if err != nil {
+			return false, err
+		}
+		return n.Contains(ip), nil
+	default:
+		return false, fmt.Errorf("unsupported routing match: %s", r.Match)
+	}
+}
+
+type Decision struct {
+	Matched bool
+	RuleID  string
+	Rule    string
+	Action  RoutingAction
+	Target  string
+}
+
+// Resolve returns the first enabled rule, in priority order, that matches
+// the destination. An unmatched Decision means the caller's default route.
+func (s *RoutingStore) Resolve(host string, ip net.IP) (Decision, error) {
+	for _, r := range s.List() {
+		if !r.Enabled {
+			continue
+		}
+		ok, err := r.Matches(host, ip)
+		if err != nil {
+			return Decision{}, fmt.Errorf("routing rule %s: %w", r.Name, err)
+		}
+		if ok {
+			return Decision{Matched: true, RuleID: r.ID, Rule: r.Name, Action: r.Action, Target: r.Target}, nil
+		}
+	}
+	return Decision{}, nil
+}
+
+func normalizeHost(h string) string {
+	h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+	return strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+}
+
+func globPath(s string) string {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+	return strings.ReplaceAll(s, ".", "/")
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR: %s", s)
+	}
+	return n, nil
+}
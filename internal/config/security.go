@@ -10,8 +10,9 @@ type SecuritySettings struct {
 }
 
 type SecurityStore struct {
-	mu  sync.RWMutex
-	cur SecuritySettings
+	mu       sync.RWMutex
+	cur      SecuritySettings
+	onChange func()
 }
 
 func NewSecurityStore() *SecurityStore {
@@ -26,6 +27,16 @@ func (s *SecurityStore) Get() SecuritySettings {
 
 func (s *SecurityStore) Set(v SecuritySettings) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.cur = v
+	fn := s.onChange
+	s.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (s *SecurityStore) SetOnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
 }
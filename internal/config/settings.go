@@ -1,13 +1,149 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type UpstreamMode string
+
+const (
+	UpstreamActive       UpstreamMode = "active"
+	UpstreamProfileChain UpstreamMode = "profile"
+	UpstreamChain        UpstreamMode = "chain"
+	// UpstreamSticky gives each target host its own proxy from the active
+	// profile's chain, kept until it has been idle for the StickyTTL.
+	UpstreamSticky UpstreamMode = "sticky"
+	// UpstreamRotate picks the next proxy of the active profile's chain for
+	// every new connection without changing the active proxy.
+	UpstreamRotate UpstreamMode = "rotate"
+)
+
+type ListenerSettings struct {
+	HTTPAddr  string
+	SOCKSAddr string
+	SOCKSUser string
+	SOCKSPass string
+	// SOCKSPassRef points at SOCKSPass in the credential vault once it has
+	// been moved there.
+	SOCKSPassRef string
+
+	// Upstream selects what listener traffic is tunneled through: the active
+	// proxy, every hop of the active profile's chain, or the named chain.
+	Upstream      UpstreamMode
+	UpstreamChain string
+}
+
+// ParseUpstream accepts "active", "profile", "sticky", "rotate" or
+// "chain:<name>".
+func ParseUpstream(s string) (UpstreamMode, string, error) {
+	mode, name, _ := strings.Cut(strings.TrimSpace(s), ":")
+	switch UpstreamMode(mode) {
+	case UpstreamActive, UpstreamProfileChain, UpstreamSticky, UpstreamRotate:
+		return UpstreamMode(mode), "", nil
+	case UpstreamChain:
+		if name == "" {
+			return "", "", fmt.Errorf("chain name required: %s", s)
+		}
+		return UpstreamChain, name, nil
+	default:
+		return "", "", fmt.Errorf("unsupported upstream: %s", s)
+	}
+}
+
+// HealthCheckSettings configure the periodic test of every proxy.
+type HealthCheckSettings struct {
+	// Interval between runs; zero disables the checker.
+	Interval    time.Duration
+	Concurrency int
+	// Timeout applies to each proxy's test.
+	Timeout time.Duration
+	// Rise and Fall are the consecutive successes/failures that mark a
+	// proxy up or down.
+	Rise int
+	Fall int
+}
+
+type healthCheckJSON struct {
+	Interval    string
+	Concurrency int
+	Timeout     string
+	Rise        int
+	Fall        int
+}
+
+// Durations are written as strings like RotationPolicy's; fields missing
+// from the input keep their current values.
+func (h HealthCheckSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(healthCheckJSON{
+		Interval:    h.Interval.String(),
+		Concurrency: h.Concurrency,
+		Timeout:     h.Timeout.String(),
+		Rise:        h.Rise,
+		Fall:        h.Fall,
+	})
+}
+
+func (h *HealthCheckSettings) UnmarshalJSON(b []byte) error {
+	v := struct {
+		Interval    json.RawMessage
+		Concurrency int
+		Timeout     json.RawMessage
+		Rise        int
+		Fall        int
+	}{Concurrency: h.Concurrency, Rise: h.Rise, Fall: h.Fall}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	out := HealthCheckSettings{Interval: h.Interval, Concurrency: v.Concurrency, Timeout: h.Timeout, Rise: v.Rise, Fall: v.Fall}
+	var err error
+	if len(v.Interval) > 0 {
+		if out.Interval, err = parseJSONDuration(v.Interval); err != nil {
+			return fmt.Errorf("health check interval: %w", err)
+		}
+	}
+	if len(v.Timeout) > 0 {
+		if out.Timeout, err = parseJSONDuration(v.Timeout); err != nil {
+			return fmt.Errorf("health check timeout: %w", err)
+		}
+	}
+	*h = out
+	return nil
+}
+
 type Settings struct {
 	Theme          string
 	DefaultProfile string
+	Listeners      ListenerSettings
+	HealthCheck    HealthCheckSettings
+	// ProbeTarget is the host:port proxy tests CONNECT to; empty checks
+	// only the proxy handshake.
+	ProbeTarget string
+	// EchoURL is requested through a proxy to learn its exit IP and
+	// anonymity level. It must answer with the caller's address and request
+	// headers, like httpbin's /get.
+	EchoURL string
+	// SpeedTestURL is downloaded by bandwidth tests; "{bytes}" is replaced
+	// by the requested size.
+	SpeedTestURL string
 }
 
 func DefaultSettings() *Settings {
 	return &Settings{
 		Theme:          "htb-dark",
 		DefaultProfile: "htb-pentest",
+		EchoURL:        "http://httpbin.org/get",
+		SpeedTestURL:   "https://speed.cloudflare.com/__down?bytes={bytes}",
+		Listeners: ListenerSettings{
+			Upstream: UpstreamActive,
+		},
+		HealthCheck: HealthCheckSettings{
+			Concurrency: 8,
+			Timeout:     3 * time.Second,
+			Rise:        2,
+			Fall:        3,
+		},
 	}
 }
@@ -0,0 +1,213 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSize bounds the events kept in memory. Debug events, such as one
+// per listener connection, have a ring of DebugSize of their own so a busy
+// listener cannot push everything else out.
+const (
+	DefaultSize = 1000
+	DebugSize   = 200
+)
+
+type Level string
+
+const (
+	LevelDebug Level = "debug"
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+var levelRank = map[Level]int{LevelDebug: 0, LevelInfo: 1, LevelWarn: 2, LevelError: 3}
+
+func ParseLevel(s string) (Level, error) {
+	if _, ok := levelRank[Level(s)]; !ok {
+		return "", fmt.Errorf("unsupported level: %s", s)
+	}
+	return Level(s), nil
+}
+
+const (
+	TopicProxy    = "proxy"
+	TopicTest     = "test"
+	TopicRotation = "rotation"
+	TopicAPI      = "api"
+	TopicListener = "listener"
+	TopicProfile  = "profile"
+	TopicRouting  = "routing"
+)
+
+// Topics lists the topics events are published under.
+var Topics = []string{TopicProxy, TopicProfile, TopicTest, TopicRotation, TopicRouting, TopicAPI, TopicListener}
+
+type Event struct {
+	// ID increases by one per event and works as a cursor for Filter.Since.
+	ID      uint64            `json:"id"`
+	At      time.Time         `json:"at"`
+	Level   Level             `json:"level"`
+	Topic   string            `json:"topic"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Filter selects events; zero values match everything.
+type Filter struct {
+	// Since only matches events after this ID.
+	Since    uint64
+	MinLevel Level
+	// Topics matches any of the listed topics.
+	Topics []string
+	// Limit caps the number of events returned, oldest first.
+	Limit int
+}
+
+func (f Filter) Match(e Event) bool {
+	if e.ID <= f.Since {
+		return false
+	}
+	if f.MinLevel != "" && levelRank[e.Level] < levelRank[f.MinLevel] {
+		return false
+	}
+	if len(f.Topics) == 0 {
+		return true
+	}
+	for _, t := range f.Topics {
+		if t == e.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// ring keeps the latest len(buf) events.
+type ring struct {
+	buf  []Event
+	next int
+	full bool
+}
+
+func (r *ring) add(e Event) {
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// ordered returns the buffered events, oldest first.
+func (r *ring) ordered() []Event {
+	if !r.full {
+		return r.buf[:r.next]
+	}
+	return append(append([]Event(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// Bus keeps the latest events in ring buffers and hands new ones to
+// subscribers.
+type Bus struct {
+	mu     sync.RWMutex
+	events ring
+	debug  ring
+	lastID uint64
+	subs   map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	f  Filter
+	ch chan Event
+}
+
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Bus{
+		events: ring{buf: make([]Event, size)},
+		debug:  ring{buf: make([]Event, DebugSize)},
+		subs:   make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *Bus) Publish(level Level, topic, message string, fields map[string]string) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e := Event{ID: b.lastID, At: time.Now().UTC(), Level: level, Topic: topic, Message: message, Fields: fields}
+	if level == LevelDebug {
+		b.debug.add(e)
+	} else {
+		b.events.add(e)
+	}
+	for s := range b.subs {
+		if !s.f.Match(e) {
+			continue
+		}
+		// a subscriber that does not keep up misses events rather than
+		// blocking publishers
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+	return e
+}
+
+// Subscribe returns a channel receiving new events that match f (Since and
+// Limit are ignored) and a function that ends the subscription and closes
+// the channel.
+func (b *Bus) Subscribe(f Filter, buffer int) (<-chan Event, func()) {
+	f.Since, f.Limit = 0, 0
+	s := &subscriber{f: f, ch: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+// Events returns the buffered events matching f, oldest first.
+func (b *Bus) Events(f Filter) []Event {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	events := b.events.ordered()
+	var debug []Event
+	if f.MinLevel == "" || f.MinLevel == LevelDebug {
+		debug = b.debug.ordered()
+	}
+	out := []Event{}
+	for len(events) > 0 || len(debug) > 0 {
+		var e Event
+		if len(debug) == 0 || len(events) > 0 && events[0].ID < debug[0].ID {
+			e, events = events[0], events[1:]
+		} else {
+			e, debug = debug[0], debug[1:]
+		}
+		if !f.Match(e) {
+			continue
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// LastID is the ID of the latest event, or 0.
+func (b *Bus) LastID() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastID
+}
@@ -0,0 +1,32 @@
+package events
+
+import "testing"
+
+func TestDebugEventsKeepTheirOwnRing(t *testing.T) {
+	b := NewBus(10)
+	b.Publish(LevelInfo, TopicProxy, "switched", nil)
+	for i := 0; i < 5*DebugSize; i++ {
+		b.Publish(LevelDebug, TopicListener, "accepted", nil)
+	}
+	b.Publish(LevelWarn, TopicAPI, "denied", nil)
+
+	all := b.Events(Filter{})
+	if len(all) != DebugSize+2 {
+		t.Fatalf("kept %d events, want %d", len(all), DebugSize+2)
+	}
+	if all[0].Message != "switched" || all[len(all)-1].Message != "denied" {
+		t.Errorf("first %q, last %q", all[0].Message, all[len(all)-1].Message)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].ID <= all[i-1].ID {
+			t.Fatalf("events out of order at %d: %d after %d", i, all[i].ID, all[i-1].ID)
+		}
+	}
+
+	if got := b.Events(Filter{MinLevel: LevelInfo}); len(got) != 2 {
+		t.Errorf("info and up = %d events, want 2", len(got))
+	}
+	if got := b.Events(Filter{Since: all[0].ID, Limit: 3}); len(got) != 3 || got[0].Topic != TopicListener {
+		t.Errorf("since/limit = %+v", got)
+	}
+}
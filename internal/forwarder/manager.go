@@ -0,0 +1,202 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindHTTP   Kind = "http"
+	KindSOCKS5 Kind = "socks5"
+)
+
+func ParseKind(s string) (Kind, error) {
+	switch Kind(s) {
+	case KindHTTP, KindSOCKS5:
+		return Kind(s), nil
+	default:
+		return "", fmt.Errorf("unsupported listener kind: %s", s)
+	}
+}
+
+type Options struct {
+	User string
+	Pass string
+}
+
+type Status struct {
+	Kind      Kind      `json:"kind"`
+	Addr      string    `json:"addr"`
+	Auth      bool      `json:"auth"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type server interface {
+	Serve(ln net.Listener) error
+	Close() error
+}
+
+type running struct {
+	status Status
+	srv    server
+}
+
+type Manager struct {
+	mu     sync.Mutex
+	dialer Dialer
+	byKind map[Kind]*running
+	stats  map[Kind]*counters
+	onConn func(ConnEvent)
+}
+
+func NewManager(d Dialer) *Manager {
+	return &Manager{
+		dialer: d,
+		byKind: make(map[Kind]*running),
+		stats:  make(map[Kind]*counters),
+	}
+}
+
+// SetOnConn sets a function called when a connection to a listener opens
+// or closes. It applies to listeners started afterwards.
+func (m *Manager) SetOnConn(fn func(ConnEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConn = fn
+}
+
+func (m *Manager) Start(kind Kind, addr string, opts Options) error {
+	if addr == "" {
+		return errors.New("listener address required")
+	}
+	if kind == KindHTTP && opts.User != "" {
+		return errors.New("http listener does not support authentication")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.byKind[kind]; ok {
+		return fmt.Errorf("%s listener already running", kind)
+	}
+
+	var srv server
+	switch kind {
+	case KindHTTP:
+		srv = NewHTTPServer(m.dialer)
+	case KindSOCKS5:
+		srv = NewSOCKS5Server(m.dialer, opts)
+	default:
+		return fmt.Errorf("unsupported listener kind: %s", kind)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	rl := &running{
+		status: Status{
+			Kind:      kind,
+			Addr:      ln.Addr().String(),
+			Auth:      opts.User != "",
+			StartedAt: time.Now().UTC(),
+		},
+		srv: srv,
+	}
+	m.byKind[kind] = rl
+	c, ok := m.stats[kind]
+	if !ok {
+		c = &counters{}
+		m.stats[kind] = c
+	}
+	cl := countingListener{Listener: ln, kind: kind, c: c, notify: m.onConn}
+	go func() {
+		_ = srv.Serve(cl)
+		m.mu.Lock()
+		if m.byKind[kind] == rl {
+			delete(m.byKind, kind)
+		}
+		m.mu.Unlock()
+	}()
+	return nil
+}
+
+func (m *Manager) Stop(kind Kind) error {
+	m.mu.Lock()
+	rl, ok := m.byKind[kind]
+	if ok {
+		delete(m.byKind, kind)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s listener not running", kind)
+	}
+	return rl.srv.Close()
+}
+
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	all := m.byKind
+	m.byKind = make(map[Kind]*running)
+	m.mu.Unlock()
+	for _, rl := range all {
+		_ = rl.srv.Close()
+	}
+}
+
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Status, 0, len(m.byKind))
+	for _, rl := range m.byKind {
+		out = append(out, rl.status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+// Stats returns the counters of every listener kind started so far.
+func (m *Manager) Stats() []Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Stats, 0, len(m.stats))
+	for kind, c := range m.stats {
+		out = append(out, c.snapshot(kind))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+type connSet struct {
+	mu sync.Mutex
+	m  map[net.Conn]struct{}
+}
+
+func (s *connSet) add(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[net.Conn]struct{})
+	}
+	s.m[c] = struct{}{}
+}
+
+func (s *connSet) remove(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, c)
+}
+
+func (s *connSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.m {
+		_ = c.Close()
+	}
+	s.m = nil
+}
@@ -0,0 +1,292 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5RepSucceeded        = 0x00
+	socks5RepGeneralFailure   = 0x01
+	socks5RepNetUnreachable   = 0x03
+	socks5RepHostUnreachable  = 0x04
+	socks5RepConnRefused      = 0x05
+	socks5RepTTLExpired       = 0x06
+	socks5RepCmdNotSupported  = 0x07
+	socks5RepAddrNotSupported = 0x08
+
+	socks5PasswordSubnegVersion = 0x01
+)
+
+const socks5NegotiationTimeout = 10 * time.Second
+
+type SOCKS5Server struct {
+	dialer Dialer
+	user   string
+	pass   string
+
+	mu     sync.Mutex
+	ln     net.Listener
+	closed bool
+	conns  connSet
+}
+
+func NewSOCKS5Server(d Dialer, opts Options) *SOCKS5Server {
+	return &SOCKS5Server{dialer: d, user: opts.User, pass: opts.Pass}
+}
+
+func (s *SOCKS5Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *SOCKS5Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	ln := s.ln
+	s.mu.Unlock()
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	s.conns.closeAll()
+	return err
+}
+
+func (s *SOCKS5Server) handle(c net.Conn) {
+	s.conns.add(c)
+	defer s.conns.remove(c)
+	defer c.Close()
+
+	_ = c.SetDeadline(time.Now().Add(socks5NegotiationTimeout))
+	if err := s.negotiate(c); err != nil {
+		return
+	}
+	target, cmd, err := readSOCKS5Request(c)
+	if err != nil {
+		if errors.Is(err, errAddrType) {
+			_ = writeSOCKS5Reply(c, socks5RepAddrNotSupported, nil)
+		}
+		return
+	}
+
+	if cmd != socks5CmdConnect {
+		// BIND and UDP ASSOCIATE are refused: every upstream we dial through
+		// is a TCP tunnel, so serving them locally would bypass the proxy
+		_ = writeSOCKS5Reply(c, socks5RepCmdNotSupported, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	upstream, err := s.dialer.DialContext(ctx, "tcp", target)
+	cancel()
+	if err != nil {
+		_ = writeSOCKS5Reply(c, socks5ReplyCode(err), nil)
+		return
+	}
+	s.conns.add(upstream)
+	defer s.conns.remove(upstream)
+
+	if err := writeSOCKS5Reply(c, socks5RepSucceeded, upstream.LocalAddr()); err != nil {
+		_ = upstream.Close()
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+	pipe(c, upstream)
+}
+
+func (s *SOCKS5Server) negotiate(c net.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != socks5Version {
+		return errors.New("socks5: unsupported version")
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return err
+	}
+
+	want := byte(socks5AuthNone)
+	if s.user != "" {
+		want = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		_, _ = c.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return errors.New("socks5: no acceptable auth method")
+	}
+	if _, err := c.Write([]byte{socks5Version, want}); err != nil {
+		return err
+	}
+	if want == socks5AuthPassword {
+		return s.authenticate(c)
+	}
+	return nil
+}
+
+// RFC 1929 username/password sub-negotiation.
+func (s *SOCKS5Server) authenticate(c net.Conn) error {
+	var ver [2]byte
+	if _, err := io.ReadFull(c, ver[:]); err != nil {
+		return err
+	}
+	if ver[0] != socks5PasswordSubnegVersion {
+		return errors.New("socks5: unsupported auth version")
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(c, plen[:]); err != nil {
+		return err
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+
+	userOK := subtle.ConstantTimeCompare(user, []byte(s.user)) == 1
+	passOK := subtle.ConstantTimeCompare(pass, []byte(s.pass)) == 1
+	if !userOK || !passOK {
+		_, _ = c.Write([]byte{socks5PasswordSubnegVersion, 0x01})
+		return errors.New("socks5: authentication failed")
+	}
+	_, err := c.Write([]byte{socks5PasswordSubnegVersion, 0x00})
+	return err
+}
+
+var errAddrType = errors.New("socks5: unsupported address type")
+
+func readSOCKS5Request(c net.Conn) (string, byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[0] != socks5Version {
+		return "", 0, errors.New("socks5: unsupported version")
+	}
+
+	var host string
+	switch hdr[3] {
+	case 0x01:
+		b := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(b).String()
+	case 0x04:
+		b := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(b).String()
+	case 0x03:
+		var l [1]byte
+		if _, err := io.ReadFull(c, l[:]); err != nil {
+			return "", 0, err
+		}
+		b := make([]byte, l[0])
+		if _, err := io.ReadFull(c, b); err != nil {
+			return "", 0, err
+		}
+		host = string(b)
+	default:
+		return "", 0, errAddrType
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(c, port[:]); err != nil {
+		return "", 0, err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	return target, hdr[1], nil
+}
+
+func writeSOCKS5Reply(c net.Conn, rep byte, bound net.Addr) error {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if ta, ok := bound.(*net.TCPAddr); ok {
+		if ip4 := ta.IP.To4(); ip4 != nil {
+			ip = ip4
+		} else if ta.IP != nil {
+			ip = ta.IP.To16()
+		}
+		port = ta.Port
+	}
+
+	b := []byte{socks5Version, rep, 0x00}
+	if len(ip) == net.IPv4len {
+		b = append(b, 0x01)
+	} else {
+		b = append(b, 0x04)
+	}
+	b = append(b, ip...)
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	_, err := c.Write(b)
+	return err
+}
+
+func socks5ReplyCode(err error) byte {
+	var ne net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5RepConnRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5RepNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return socks5RepHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return socks5RepTTLExpired
+	default:
+		return socks5RepGeneralFailure
+	}
+}
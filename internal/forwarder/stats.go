@@ -0,0 +1,116 @@
+package forwarder
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are cumulative per listener kind and survive restarts of the
+// listener. BytesIn is read from clients, BytesOut written to them.
+type Stats struct {
+	Kind              Kind   `json:"kind"`
+	ConnectionsTotal  uint64 `json:"connections_total"`
+	ConnectionsActive int64  `json:"connections_active"`
+	BytesIn           uint64 `json:"bytes_in"`
+	BytesOut          uint64 `json:"bytes_out"`
+}
+
+type counters struct {
+	total    atomic.Uint64
+	active   atomic.Int64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func (c *counters) snapshot(kind Kind) Stats {
+	return Stats{
+		Kind:              kind,
+		ConnectionsTotal:  c.total.Load(),
+		ConnectionsActive: c.active.Load(),
+		BytesIn:           c.bytesIn.Load(),
+		BytesOut:          c.bytesOut.Load(),
+	}
+}
+
+// ConnEvent reports a listener connection being accepted or, with Closed
+// set, closed along with the bytes it moved.
+type ConnEvent struct {
+	Kind     Kind
+	Remote   string
+	Closed   bool
+	BytesIn  uint64
+	BytesOut uint64
+	Duration time.Duration
+}
+
+type countingListener struct {
+	net.Listener
+	kind   Kind
+	c      *counters
+	notify func(ConnEvent)
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.c.total.Add(1)
+	l.c.active.Add(1)
+	cc := &countingConn{Conn: conn, kind: l.kind, c: l.c, notify: l.notify, opened: time.Now()}
+	if cc.notify != nil {
+		cc.notify(ConnEvent{Kind: l.kind, Remote: conn.RemoteAddr().String()})
+	}
+	return cc, nil
+}
+
+type countingConn struct {
+	net.Conn
+	kind     Kind
+	c        *counters
+	notify   func(ConnEvent)
+	opened   time.Time
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	closed   atomic.Bool
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	cc.c.bytesIn.Add(uint64(n))
+	cc.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	cc.c.bytesOut.Add(uint64(n))
+	cc.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) Close() error {
+	if cc.closed.CompareAndSwap(false, true) {
+		cc.c.active.Add(-1)
+		if cc.notify != nil {
+			cc.notify(ConnEvent{
+				Kind:     cc.kind,
+				Remote:   cc.RemoteAddr().String(),
+				Closed:   true,
+				BytesIn:  cc.bytesIn.Load(),
+				BytesOut: cc.bytesOut.Load(),
+				Duration: time.Since(cc.opened),
+			})
+		}
+	}
+	return cc.Conn.Close()
+}
+
+// CloseWrite keeps half-closes working in pipe.
+func (cc *countingConn) CloseWrite() error {
+	if c, ok := cc.Conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return cc.Close()
+}
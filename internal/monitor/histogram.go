@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// LatencyBuckets are the histogram upper bounds, in seconds.
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type LatencyHistogram struct {
+	Proxy string
+	// Counts[i] is the number of samples <= LatencyBuckets[i]; the +Inf
+	// bucket is Count.
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(LatencyBuckets))
+	}
+	v := d.Seconds()
+	for i, le := range LatencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type RotationCount struct {
+	Profile string
+	Manual  bool
+	Count   uint64
+}
+
+type rotationKey struct {
+	profile string
+	manual  bool
+}
+
+func (s *Store) RecordRotation(profile string, manual bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotations[rotationKey{profile, manual}]++
+}
+
+func (s *Store) Rotations() []RotationCount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]RotationCount, 0, len(s.rotations))
+	for k, n := range s.rotations {
+		out = append(out, RotationCount{Profile: k.profile, Manual: k.manual, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Profile != out[j].Profile {
+			return out[i].Profile < out[j].Profile
+		}
+		return !out[i].Manual && out[j].Manual
+	})
+	return out
+}
+
+// Histograms returns the latency histogram of successful tests per proxy.
+func (s *Store) Histograms() []LatencyHistogram {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]LatencyHistogram, 0, len(s.latency))
+	for name, h := range s.latency {
+		out = append(out, LatencyHistogram{
+			Proxy:  name,
+			Counts: append([]uint64(nil), h.counts...),
+			Sum:    h.sum,
+			Count:  h.count,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Proxy < out[j].Proxy })
+	return out
+}
@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// HistorySize bounds the samples kept per proxy for the latency figures.
+// Availability is counted per minute over the last AvailabilityWindow, so
+// it covers a full day however often proxies are tested.
+const (
+	HistorySize        = 1440
+	AvailabilityWindow = 24 * time.Hour
+)
+
+const availabilityBuckets = int(AvailabilityWindow / time.Minute)
+
+type Sample struct {
+	At      time.Time     `json:"at"`
+	OK      bool          `json:"ok"`
+	Latency time.Duration `json:"latency"`
+}
+
+// ring keeps the latest HistorySize samples. buf grows up to that size
+// and is then overwritten from head.
+type ring struct {
+	buf  []Sample
+	head int
+}
+
+func (r *ring) add(s Sample) {
+	if len(r.buf) < HistorySize {
+		r.buf = append(r.buf, s)
+		return
+	}
+	r.buf[r.head] = s
+	r.head = (r.head + 1) % len(r.buf)
+}
+
+// samples returns the buffered samples, oldest first.
+func (r *ring) samples() []Sample {
+	out := make([]Sample, 0, len(r.buf))
+	out = append(out, r.buf[r.head:]...)
+	return append(out, r.buf[:r.head]...)
+}
+
+// bucket counts the tests of one minute.
+type bucket struct {
+	minute    int64
+	ok, total int32
+}
+
+// timeline is the history of one proxy: recent samples and per-minute
+// counts for the availability window, indexed by minute.
+type timeline struct {
+	recent  ring
+	buckets *[availabilityBuckets]bucket
+}
+
+func (t *timeline) add(s Sample) {
+	t.recent.add(s)
+	if t.buckets == nil {
+		t.buckets = new([availabilityBuckets]bucket)
+	}
+	m := s.At.Unix() / 60
+	b := &t.buckets[m%int64(availabilityBuckets)]
+	if b.minute != m {
+		*b = bucket{minute: m}
+	}
+	b.total++
+	if s.OK {
+		b.ok++
+	}
+}
+
+// availability is the percentage of successful tests since since, or nil
+// without tests. Whole minutes are counted, so the window starts at the
+// minute since falls in.
+func (t *timeline) availability(since time.Time) *float64 {
+	if t.buckets == nil {
+		return nil
+	}
+	from := since.Unix() / 60
+	var ok, total int64
+	for _, b := range t.buckets {
+		if b.total > 0 && b.minute >= from {
+			ok += int64(b.ok)
+			total += int64(b.total)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	v := float64(ok) * 100 / float64(total)
+	return &v
+}
+
+// History is the sample buffer of one proxy with statistics over it.
+// Samples and the latency figures cover the last HistorySize tests, from
+// Since; latency figures only use successful, timed samples.
+// Availabilities are percentages over the last hour and day, and nil when
+// there were no tests.
+type History struct {
+	Name            string        `json:"name"`
+	Since           time.Time     `json:"since"`
+	Samples         []Sample      `json:"samples"`
+	P50             time.Duration `json:"p50"`
+	P90             time.Duration `json:"p90"`
+	P99             time.Duration `json:"p99"`
+	Jitter          time.Duration `json:"jitter"`
+	Availability1h  *float64      `json:"availability_1h"`
+	Availability24h *float64      `json:"availability_24h"`
+}
+
+func (s *Store) History(proxyName string) (History, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.history[proxyName]
+	if !ok {
+		return History{}, false
+	}
+	now := time.Now()
+	h := summarize(proxyName, t.recent.samples())
+	h.Availability1h = t.availability(now.Add(-time.Hour))
+	h.Availability24h = t.availability(now.Add(-AvailabilityWindow))
+	return h, true
+}
+
+func summarize(name string, samples []Sample) History {
+	h := History{Name: name, Samples: samples}
+	if len(samples) > 0 {
+		h.Since = samples[0].At
+	}
+
+	var lat []time.Duration
+	var diffSum time.Duration
+	for _, s := range samples {
+		if !s.OK || s.Latency == 0 {
+			continue
+		}
+		if n := len(lat); n > 0 {
+			d := s.Latency - lat[n-1]
+			if d < 0 {
+				d = -d
+			}
+			diffSum += d
+		}
+		lat = append(lat, s.Latency)
+	}
+	if len(lat) > 1 {
+		h.Jitter = diffSum / time.Duration(len(lat)-1)
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	h.P50 = percentile(lat, 50)
+	h.P90 = percentile(lat, 90)
+	h.P99 = percentile(lat, 99)
+	return h
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
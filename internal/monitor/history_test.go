@@ -0,0 +1,49 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRingKeepsLatestSamples(t *testing.T) {
+	start := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	var r ring
+	for i := 0; i < HistorySize+100; i++ {
+		r.add(Sample{At: start.Add(time.Duration(i) * time.Second), OK: true})
+	}
+	if cap(r.buf) > 2*HistorySize {
+		t.Errorf("buffer capacity %d", cap(r.buf))
+	}
+	got := r.samples()
+	if len(got) != HistorySize {
+		t.Fatalf("kept %d samples, want %d", len(got), HistorySize)
+	}
+	for i := 1; i < len(got); i++ {
+		if !got[i].At.After(got[i-1].At) {
+			t.Fatalf("samples out of order at %d", i)
+		}
+	}
+	if want := start.Add(100 * time.Second); !got[0].At.Equal(want) {
+		t.Errorf("oldest sample at %s, want %s", got[0].At, want)
+	}
+	if h := summarize("lab", got); !h.Since.Equal(got[0].At) {
+		t.Errorf("since = %s", h.Since)
+	}
+}
+
+func TestAvailabilityCoversTheDay(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	var tl timeline
+	// one test every 10s for 30h, far more than the ring holds; the proxy
+	// was down until the last hour
+	for at := now.Add(-30 * time.Hour); !at.After(now); at = at.Add(10 * time.Second) {
+		tl.add(Sample{At: at, OK: at.After(now.Add(-time.Hour))})
+	}
+	if a := tl.availability(now.Add(-time.Hour)); a == nil || *a < 98 {
+		t.Errorf("availability 1h = %v", a)
+	}
+	// 1h up out of 24h; counting the 6h before the window would give 1/30
+	if a := tl.availability(now.Add(-AvailabilityWindow)); a == nil || *a < 3.5 || *a > 5 {
+		t.Errorf("availability 24h = %v", a)
+	}
+}
@@ -9,23 +9,64 @@ import (
 )
 
 type ProxyMetrics struct {
-	Name        string        `json:"name"`
-	Successes   uint64        `json:"successes"`
-	Failures    uint64        `json:"failures"`
-	LastOK      bool          `json:"last_ok"`
-	LastLatency time.Duration `json:"last_latency"`
-	LastError   string        `json:"last_error"`
-	LastTestAt  time.Time     `json:"last_test_at"`
+	Name                string        `json:"name"`
+	Successes           uint64        `json:"successes"`
+	Failures            uint64        `json:"failures"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	ConsecutiveOK       int           `json:"consecutive_ok"`
+	State               State         `json:"state,omitempty"`
+	LastOK              bool          `json:"last_ok"`
+	LastLatency         time.Duration `json:"last_latency"`
+	LastHandshake       time.Duration `json:"last_handshake"`
+	LastTTFB            time.Duration `json:"last_ttfb"`
+	LastStage           proxy.Stage   `json:"last_stage,omitempty"`
+	LastCode            int           `json:"last_code,omitempty"`
+	LastError           string        `json:"last_error"`
+	LastTestAt          time.Time     `json:"last_test_at"`
+
+	ExitIP         string          `json:"exit_ip,omitempty"`
+	Anonymity      proxy.Anonymity `json:"anonymity,omitempty"`
+	AnonymityLeaks []string        `json:"anonymity_leaks,omitempty"`
+	ExitError      string          `json:"exit_error,omitempty"`
+	ExitCheckedAt  *time.Time      `json:"exit_checked_at,omitempty"`
 }
 
+// State is a proxy's up/down verdict. It only flips after Rise consecutive
+// successes or Fall consecutive failures, so one flaky test does not.
+type State string
+
+const (
+	StateUp   State = "up"
+	StateDown State = "down"
+)
+
+const (
+	DefaultRise = 2
+	DefaultFall = 3
+)
+
 type Store struct {
-	mu      sync.RWMutex
-	started time.Time
-	byProxy map[string]*ProxyMetrics
+	mu        sync.RWMutex
+	rise      int
+	fall      int
+	started   time.Time
+	byProxy   map[string]*ProxyMetrics
+	latency   map[string]*histogram
+	history   map[string]*timeline
+	rotations map[rotationKey]uint64
+	onTest    func(string, proxy.TestResult)
 }
 
 func NewStore() *Store {
-	return &Store{started: time.Now().UTC(), byProxy: make(map[string]*ProxyMetrics)}
+	return &Store{
+		started:   time.Now().UTC(),
+		rise:      DefaultRise,
+		fall:      DefaultFall,
+		byProxy:   make(map[string]*ProxyMetrics),
+		latency:   make(map[string]*histogram),
+		history:   make(map[string]*timeline),
+		rotations: make(map[rotationKey]uint64),
+	}
 }
 
 func (s *Store) StartedAt() time.Time {
@@ -34,26 +75,134 @@ func (s *Store) StartedAt() time.Time {
 	return s.started
 }
 
+// SetOnTest sets a function called after every recorded test.
+func (s *Store) SetOnTest(fn func(proxyName string, tr proxy.TestResult)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onTest = fn
+}
+
 func (s *Store) RecordTest(proxyName string, tr proxy.TestResult) {
 	if proxyName == "" {
 		return
 	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.recordTest(proxyName, tr)
+	fn := s.onTest
+	s.mu.Unlock()
+	if fn != nil {
+		fn(proxyName, tr)
+	}
+}
+
+func (s *Store) recordTest(proxyName string, tr proxy.TestResult) {
 	m, ok := s.byProxy[proxyName]
 	if !ok {
 		m = &ProxyMetrics{Name: proxyName}
 		s.byProxy[proxyName] = m
 	}
+	// a success without timings, like that of a later chain hop, counts
+	// for health but leaves the latency figures alone
+	timed := !tr.OK || tr.Latency > 0
 	m.LastOK = tr.OK
-	m.LastLatency = tr.Latency
+	if timed {
+		m.LastLatency = tr.Latency
+		m.LastHandshake = tr.Handshake
+		m.LastTTFB = tr.TTFB
+	}
+	m.LastStage = tr.Stage
+	m.LastCode = tr.Code
 	m.LastError = tr.Error
 	m.LastTestAt = time.Now().UTC()
+	t, ok := s.history[proxyName]
+	if !ok {
+		t = &timeline{}
+		s.history[proxyName] = t
+	}
+	t.add(Sample{At: m.LastTestAt, OK: tr.OK, Latency: tr.Latency})
 	if tr.OK {
 		m.Successes++
+		m.ConsecutiveFailures = 0
+		m.ConsecutiveOK++
+		if timed {
+			h, ok := s.latency[proxyName]
+			if !ok {
+				h = &histogram{}
+				s.latency[proxyName] = h
+			}
+			h.observe(tr.Latency)
+		}
 	} else {
 		m.Failures++
+		m.ConsecutiveFailures++
+		m.ConsecutiveOK = 0
+	}
+	switch {
+	case m.State == "":
+		// the first result decides until there is a history
+		m.State = StateDown
+		if tr.OK {
+			m.State = StateUp
+		}
+	case m.State == StateDown && m.ConsecutiveOK >= s.rise:
+		m.State = StateUp
+	case m.State == StateUp && m.ConsecutiveFailures >= s.fall:
+		m.State = StateDown
+	}
+}
+
+// RecordAnonymity stores the outcome of an exit IP check. A failed check
+// keeps the last known exit IP and anonymity level.
+func (s *Store) RecordAnonymity(proxyName string, ar proxy.AnonymityResult) {
+	if proxyName == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.byProxy[proxyName]
+	if !ok {
+		m = &ProxyMetrics{Name: proxyName}
+		s.byProxy[proxyName] = m
+	}
+	at := time.Now().UTC()
+	m.ExitCheckedAt = &at
+	m.ExitError = ar.Error
+	if !ar.OK {
+		return
+	}
+	m.ExitIP = ar.ExitIP
+	m.Anonymity = ar.Anonymity
+	m.AnonymityLeaks = ar.Leaks
+}
+
+// SetHysteresis sets how many consecutive results flip a proxy's State;
+// values below 1 keep the current setting.
+func (s *Store) SetHysteresis(rise, fall int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rise > 0 {
+		s.rise = rise
+	}
+	if fall > 0 {
+		s.fall = fall
+	}
+}
+
+// Health implements proxy.HealthSource.
+func (s *Store) Health(proxyName string) (proxy.Health, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.byProxy[proxyName]
+	if !ok {
+		return proxy.Health{}, false
 	}
+	return proxy.Health{
+		LastOK:              m.LastOK,
+		ConsecutiveFailures: m.ConsecutiveFailures,
+		LastTestAt:          m.LastTestAt,
+		LastLatency:         m.LastLatency,
+		Failures:            m.Failures,
+	}, true
 }
 
 func (s *Store) Snapshot() []ProxyMetrics {
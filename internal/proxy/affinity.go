@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+)
+
+// DefaultStickyTTL is how long an idle host keeps its upstream when the
+// policy sets no StickyTTL.
+const DefaultStickyTTL = 10 * time.Minute
+
+type affinityEntry struct {
+	proxy   string
+	expires time.Time
+}
+
+type Affinity struct {
+	Profile   string    `json:"profile"`
+	Host      string    `json:"host"`
+	Proxy     string    `json:"proxy"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sticky returns the proxy assigned to host within profileName, assigning
+// the next one from chain under policy when host has none or its
+// assignment expired, left the chain or (for health-checked policies)
+// became unhealthy. Each use extends the assignment by the TTL. The
+// manager's active proxy is never changed.
+func (r *Rotator) Sticky(profileName, host string, chain []string, policy config.RotationPolicy, mgr *Manager) (Proxy, error) {
+	if mgr == nil {
+		return Proxy{}, errors.New("proxy manager required")
+	}
+	ttl := policy.StickyTTL
+	if ttl <= 0 {
+		ttl = DefaultStickyTTL
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+	now := r.now()
+	e, ok := r.affinity[profileName][host]
+	health := r.health
+	r.mu.Unlock()
+
+	if ok && now.Before(e.expires) && contains(chain, e.proxy) {
+		if p, found := mgr.GetByName(e.proxy); found && r.stillHealthy(p, policy, health) {
+			r.assign(profileName, host, p.Name, now.Add(ttl))
+			return p, nil
+		}
+	}
+
+	p, err := r.Select(profileName, chain, policy, mgr)
+	if err != nil {
+		return Proxy{}, err
+	}
+	r.assign(profileName, host, p.Name, now.Add(ttl))
+	return p, nil
+}
+
+// PeekSticky reports what Sticky would return for host without assigning
+// or extending anything.
+func (r *Rotator) PeekSticky(profileName, host string, chain []string, policy config.RotationPolicy, mgr *Manager) (Proxy, error) {
+	if mgr == nil {
+		return Proxy{}, errors.New("proxy manager required")
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mu.Lock()
+	e, ok := r.affinity[profileName][host]
+	now, health := r.now(), r.health
+	r.mu.Unlock()
+	if ok && now.Before(e.expires) && contains(chain, e.proxy) {
+		if p, found := mgr.GetByName(e.proxy); found && r.stillHealthy(p, policy, health) {
+			return p, nil
+		}
+	}
+	return r.Peek(profileName, chain, policy, mgr)
+}
+
+// stillHealthy re-checks a kept assignment against recorded results only;
+// retesting on every connection would be far too slow.
+func (r *Rotator) stillHealthy(p Proxy, policy config.RotationPolicy, src HealthSource) bool {
+	if !policy.HealthChecked() {
+		return true
+	}
+	policy.Retest = false
+	return r.healthy(p, policy, src, nil)
+}
+
+// affinitySweepEvery bounds how often assign drops expired assignments.
+const affinitySweepEvery = time.Minute
+
+func (r *Rotator) assign(profileName, host, proxyName string, expires time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// every host a listener sees gets an entry, so expired ones are dropped
+	// here too, not only when they are listed
+	if now := r.now(); now.Sub(r.lastSweep) >= affinitySweepEvery {
+		r.pruneAffinity(now)
+		r.lastSweep = now
+	}
+	byHost, ok := r.affinity[profileName]
+	if !ok {
+		byHost = make(map[string]affinityEntry)
+		r.affinity[profileName] = byHost
+	}
+	byHost[host] = affinityEntry{proxy: proxyName, expires: expires}
+}
+
+// pruneAffinity drops assignments expired at now. Callers hold r.mu.
+func (r *Rotator) pruneAffinity(now time.Time) {
+	for profile, byHost := range r.affinity {
+		for host, e := range byHost {
+			if !now.Before(e.expires) {
+				delete(byHost, host)
+			}
+		}
+		if len(byHost) == 0 {
+			delete(r.affinity, profile)
+		}
+	}
+}
+
+// Affinities lists unexpired host assignments, dropping expired ones.
+func (r *Rotator) Affinities() []Affinity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneAffinity(r.now())
+	var out []Affinity
+	for profile, byHost := range r.affinity {
+		for host, e := range byHost {
+			out = append(out, Affinity{Profile: profile, Host: host, Proxy: e.proxy, ExpiresAt: e.expires})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Profile != out[j].Profile {
+			return out[i].Profile < out[j].Profile
+		}
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// ClearAffinity forgets assignments for host within profileName. Empty
+// arguments match everything.
+func (r *Rotator) ClearAffinity(profileName, host string) int {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for profile, byHost := range r.affinity {
+		if profileName != "" && profile != profileName {
+			continue
+		}
+		for h := range byHost {
+			if host != "" && h != host {
+				continue
+			}
+			delete(byHost, h)
+			n++
+		}
+		if len(byHost) == 0 {
+			delete(r.affinity, profile)
+		}
+	}
+	return n
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
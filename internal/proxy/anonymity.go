@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Anonymity string
+
+const (
+	// AnonymityTransparent proxies pass our address on to the target.
+	AnonymityTransparent Anonymity = "transparent"
+	// AnonymityAnonymous proxies hide our address but announce themselves.
+	AnonymityAnonymous Anonymity = "anonymous"
+	// AnonymityElite proxies look like an ordinary client to the target.
+	AnonymityElite Anonymity = "elite"
+)
+
+// addressHeaders are the proxy headers that carry client addresses.
+var addressHeaders = map[string]bool{
+	"X-Forwarded-For":     true,
+	"Forwarded":           true,
+	"X-Real-Ip":           true,
+	"Client-Ip":           true,
+	"X-Client-Ip":         true,
+	"X-Originating-Ip":    true,
+	"True-Client-Ip":      true,
+	"X-Cluster-Client-Ip": true,
+}
+
+// proxyHeaders are the request headers proxies add that give them away.
+var proxyHeaders = []string{
+	"Via",
+	"X-Forwarded-For",
+	"Forwarded",
+	"X-Real-Ip",
+	"X-Forwarded-Host",
+	"X-Proxy-Id",
+	"Proxy-Connection",
+	"Client-Ip",
+	"X-Client-Ip",
+	"X-Originating-Ip",
+	"True-Client-Ip",
+	"X-Cluster-Client-Ip",
+}
+
+type AnonymityResult struct {
+	OK        bool
+	ExitIP    string
+	Anonymity Anonymity
+	// Leaks lists the proxy headers that reached the target.
+	Leaks   []string
+	Latency time.Duration
+	Error   string
+}
+
+// echo is what an echo endpoint reports about the request it received.
+// Endpoints answer either with JSON carrying "origin" (or "ip") and
+// "headers", like httpbin's /get, or with the bare client address.
+type echo struct {
+	// Origin lists the client addresses, forwarded ones first, so the last
+	// entry is the address the endpoint was connected from.
+	Origin  []string
+	Headers http.Header
+}
+
+// CheckAnonymity requests echoURL through p and classifies what the
+// endpoint saw. Our addresses are known from the local address of the
+// connection to p, which covers proxies on the same network; a forwarded
+// address that is neither ours nor the exit IP is taken to be ours too.
+//
+// With baseline set, echoURL is also requested directly, which reveals our
+// public address to the endpoint. In exchange, headers the endpoint's own
+// infrastructure adds are told apart and forwarded addresses are matched
+// exactly.
+func CheckAnonymity(ctx context.Context, p Proxy, echoURL string, baseline bool) AnonymityResult {
+	u, err := url.Parse(echoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return AnonymityResult{Error: fmt.Sprintf("invalid echo url: %q", echoURL)}
+	}
+
+	var direct echo
+	var directLocal string
+	if baseline {
+		// a failed direct request only weakens transparency detection
+		direct, _ = fetchEcho(ctx, nil, u, &directLocal)
+	}
+
+	var local string
+	start := time.Now()
+	via, err := fetchEcho(ctx, &p, u, &local)
+	if err != nil {
+		return AnonymityResult{Error: err.Error()}
+	}
+	res := AnonymityResult{OK: true, Latency: time.Since(start)}
+	if n := len(via.Origin); n > 0 {
+		res.ExitIP = via.Origin[n-1]
+	}
+
+	origin := map[string]bool{}
+	for _, ip := range append(direct.Origin, local, directLocal) {
+		if ip != "" {
+			origin[ip] = true
+		}
+	}
+	// ours reports whether a forwarded address gives us away
+	ours := func(ip string) bool {
+		if origin[ip] {
+			return true
+		}
+		return !baseline && ip != res.ExitIP && net.ParseIP(ip) != nil
+	}
+	transparent := false
+	if n := len(via.Origin); n > 1 {
+		for _, ip := range via.Origin[:n-1] {
+			transparent = transparent || ours(ip)
+		}
+	}
+	for _, h := range proxyHeaders {
+		vals := via.Headers.Values(h)
+		if len(vals) == 0 || len(direct.Headers.Values(h)) > 0 {
+			continue
+		}
+		res.Leaks = append(res.Leaks, h)
+		for _, v := range vals {
+			for ip := range origin {
+				transparent = transparent || strings.Contains(v, ip)
+			}
+			if !addressHeaders[h] {
+				continue
+			}
+			for _, ip := range headerIPs(v) {
+				transparent = transparent || ours(ip)
+			}
+		}
+	}
+	switch {
+	case transparent:
+		res.Anonymity = AnonymityTransparent
+	case len(res.Leaks) > 0:
+		res.Anonymity = AnonymityAnonymous
+	default:
+		res.Anonymity = AnonymityElite
+	}
+	return res
+}
+
+// headerIPs extracts the IP addresses in a forwarding header value, such as
+// "1.2.3.4, 5.6.7.8" or "for=1.2.3.4;proto=http".
+func headerIPs(v string) []string {
+	var out []string
+	for _, f := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		f = strings.Trim(strings.TrimPrefix(strings.ToLower(f), "for="), `"[]`)
+		if net.ParseIP(f) != nil {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// fetchEcho GETs u through p, or directly when p is nil, and stores the
+// local address of the connection in local.
+func fetchEcho(ctx context.Context, p *Proxy, u *url.URL, local *string) (echo, error) {
+	record := func(c net.Conn) {
+		if a, ok := c.LocalAddr().(*net.TCPAddr); ok {
+			*local = a.IP.String()
+		}
+	}
+	tr := &http.Transport{DisableKeepAlives: true}
+	var d net.Dialer
+	switch {
+	case p == nil:
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := d.DialContext(ctx, network, addr)
+			if err == nil {
+				record(c)
+			}
+			return c, err
+		}
+	case p.Type == TypeHTTP || p.Type == TypeHTTPS:
+		// forward plain requests through the proxy rather than tunneling
+		// them, since a tunnel gives the proxy no chance to add headers
+		pu := &url.URL{Scheme: "http", Host: p.Address()}
+		if hasCredentials(*p) {
+			pu.User = url.UserPassword(p.User, p.Pass)
+		}
+		tr.Proxy = http.ProxyURL(pu)
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := d.DialContext(ctx, "tcp", p.Address())
+			if err != nil {
+				return nil, stageErr(StageDial, err)
+			}
+			record(c)
+			tunnel, err := negotiate(ctx, c, *p)
+			if err != nil {
+				_ = c.Close()
+				return nil, err
+			}
+			return tunnel, nil
+		}
+	default:
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := Dial(ctx, *p, network, addr)
+			if err == nil {
+				record(c)
+			}
+			return c, err
+		}
+	}
+	defer tr.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return echo{}, err
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return echo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return echo{}, fmt.Errorf("echo endpoint returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return echo{}, err
+	}
+	return parseEcho(body)
+}
+
+// parseEcho decodes an echo endpoint's response body.
+func parseEcho(body []byte) (echo, error) {
+	var raw struct {
+		Origin  string                     `json:"origin"`
+		IP      string                     `json:"ip"`
+		Headers map[string]json.RawMessage `json:"headers"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		ip := strings.TrimSpace(string(body))
+		if net.ParseIP(ip) == nil {
+			return echo{}, errors.New("unrecognized echo response")
+		}
+		return echo{Origin: []string{ip}, Headers: http.Header{}}, nil
+	}
+
+	e := echo{Headers: http.Header{}}
+	origin := raw.Origin
+	if origin == "" {
+		origin = raw.IP
+	}
+	for _, ip := range strings.Split(origin, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			e.Origin = append(e.Origin, ip)
+		}
+	}
+	if len(e.Origin) == 0 {
+		return echo{}, errors.New("echo response has no origin")
+	}
+	for k, v := range raw.Headers {
+		// values are a string or a list of strings depending on the endpoint
+		var one string
+		if json.Unmarshal(v, &one) == nil {
+			e.Headers.Add(k, one)
+			continue
+		}
+		var many []string
+		if json.Unmarshal(v, &many) == nil {
+			for _, s := range many {
+				e.Headers.Add(k, s)
+			}
+		}
+	}
+	return e, nil
+}
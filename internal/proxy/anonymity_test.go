@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// echoServer answers like httpbin's /get. Every request gets the headers in
+// infra added first, as a load balancer in front of the endpoint would.
+func echoServer(t *testing.T, infra http.Header) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		headers := map[string]string{}
+		for k := range r.Header {
+			headers[k] = r.Header.Get(k)
+		}
+		for k := range infra {
+			headers[k] = infra.Get(k)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"origin": host, "headers": headers})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// forwardProxy is a plain HTTP forward proxy that adds headers to every
+// request it passes on.
+func forwardProxy(t *testing.T, add func(r *http.Request, out http.Header)) Proxy {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := http.NewRequest(r.Method, r.URL.String(), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out.Header = r.Header.Clone()
+		out.Header.Del("Proxy-Connection")
+		add(r, out.Header)
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return Proxy{Name: "fake", Type: TypeHTTP, Host: u.Hostname(), Port: port}
+}
+
+func TestCheckAnonymity(t *testing.T) {
+	clientIP := func(r *http.Request) string {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return host
+	}
+	tests := []struct {
+		name     string
+		add      func(r *http.Request, out http.Header)
+		infra    http.Header
+		baseline bool
+		want     Anonymity
+		leaks    int
+	}{
+		{
+			name: "transparent forwards our address",
+			add: func(r *http.Request, out http.Header) {
+				out.Set("Via", "1.1 fake")
+				out.Set("X-Forwarded-For", clientIP(r))
+			},
+			want:  AnonymityTransparent,
+			leaks: 2,
+		},
+		{
+			name: "transparent forwards a public address",
+			add: func(_ *http.Request, out http.Header) {
+				out.Set("X-Forwarded-For", "203.0.113.7")
+			},
+			want:  AnonymityTransparent,
+			leaks: 1,
+		},
+		{
+			name: "anonymous announces itself",
+			add: func(_ *http.Request, out http.Header) {
+				out.Set("Via", "1.1 fake")
+			},
+			want:  AnonymityAnonymous,
+			leaks: 1,
+		},
+		{
+			name: "elite adds nothing",
+			add:  func(*http.Request, http.Header) {},
+			want: AnonymityElite,
+		},
+		{
+			name:     "baseline discounts headers the endpoint adds",
+			add:      func(*http.Request, http.Header) {},
+			infra:    http.Header{"Via": {"1.1 edge"}},
+			baseline: true,
+			want:     AnonymityElite,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := echoServer(t, tt.infra)
+			p := forwardProxy(t, tt.add)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res := CheckAnonymity(ctx, p, echo.URL, tt.baseline)
+			if !res.OK {
+				t.Fatalf("check failed: %s", res.Error)
+			}
+			if res.Anonymity != tt.want {
+				t.Errorf("anonymity = %s, want %s (leaks %v)", res.Anonymity, tt.want, res.Leaks)
+			}
+			if len(res.Leaks) != tt.leaks {
+				t.Errorf("leaks = %v, want %d", res.Leaks, tt.leaks)
+			}
+			if res.ExitIP != "127.0.0.1" {
+				t.Errorf("exit ip = %q", res.ExitIP)
+			}
+		})
+	}
+}
+
+func TestCheckAnonymitySkipsDirectRequestByDefault(t *testing.T) {
+	direct := 0
+	echo := echoServer(t, nil)
+	counted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Via-Proxy") == "" {
+			direct++
+		}
+		echo.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counted.Close()
+	p := forwardProxy(t, func(_ *http.Request, out http.Header) { out.Set("X-Via-Proxy", "1") })
+
+	if res := CheckAnonymity(context.Background(), p, counted.URL, false); !res.OK {
+		t.Fatal(res.Error)
+	}
+	if direct != 0 {
+		t.Errorf("%d direct requests without baseline", direct)
+	}
+	if res := CheckAnonymity(context.Background(), p, counted.URL, true); !res.OK {
+		t.Fatal(res.Error)
+	}
+	if direct != 1 {
+		t.Errorf("%d direct requests with baseline, want 1", direct)
+	}
+}
@@ -2,6 +2,8 @@ package proxy
 
 import "errors"
 
+const MaxChainHops = 5
+
 type Chain struct {
 	Name string
 	Hops []string
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+type Dialer struct {
+	mgr *Manager
+}
+
+func NewDialer(mgr *Manager) *Dialer {
+	return &Dialer{mgr: mgr}
+}
+
+func (d *Dialer) Resolve(c Chain) ([]Proxy, error) {
+	if err := c.Validate(MaxChainHops); err != nil {
+		return nil, err
+	}
+	hops := make([]Proxy, 0, len(c.Hops))
+	for _, name := range c.Hops {
+		p, ok := d.mgr.GetByName(name)
+		if !ok {
+			return nil, fmt.Errorf("chain hop not found: %s", name)
+		}
+		hops = append(hops, p)
+	}
+	return hops, nil
+}
+
+func (d *Dialer) DialChain(ctx context.Context, c Chain, network, target string) (net.Conn, error) {
+	hops, err := d.Resolve(c)
+	if err != nil {
+		return nil, err
+	}
+	return DialHops(ctx, hops, network, target)
+}
+
+// DialHops connects to the first hop directly and then asks each hop in turn
+// to tunnel to the next one, so the last hop is the one that reaches target.
+func DialHops(ctx context.Context, hops []Proxy, network, target string) (net.Conn, error) {
+	if len(hops) == 0 {
+		return nil, errors.New("chain must include at least one hop")
+	}
+	if err := checkNetwork(network); err != nil {
+		return nil, err
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", hops[0].Address())
+	if err != nil {
+		return nil, fmt.Errorf("hop 1 (%s): %w", hops[0].Name, stageErr(StageDial, err))
+	}
+	for i, hop := range hops {
+		next := target
+		if i+1 < len(hops) {
+			next = hops[i+1].Address()
+		}
+		tunnel, err := Handshake(ctx, conn, hop, next)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("hop %d (%s): %w", i+1, hop.Name, err)
+		}
+		conn = tunnel
+	}
+	return conn, nil
+}
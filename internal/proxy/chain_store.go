@@ -7,21 +7,38 @@ import (
 )
 
 type ChainStore struct {
-	mu     sync.RWMutex
-	byName map[string]Chain
+	mu       sync.RWMutex
+	byName   map[string]Chain
+	onChange func()
 }
 
 func NewChainStore() *ChainStore {
 	return &ChainStore{byName: make(map[string]Chain)}
 }
 
+func (s *ChainStore) SetOnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+func (s *ChainStore) changed() {
+	s.mu.RLock()
+	fn := s.onChange
+	s.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (s *ChainStore) Upsert(c Chain, maxHops int) error {
 	if err := c.Validate(maxHops); err != nil {
 		return err
 	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.byName[c.Name] = c
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
 
@@ -30,11 +47,13 @@ func (s *ChainStore) Remove(name string) error {
 		return errors.New("chain name required")
 	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	if _, ok := s.byName[name]; !ok {
+		s.mu.Unlock()
 		return errors.New("chain not found")
 	}
 	delete(s.byName, name)
+	s.mu.Unlock()
+	s.changed()
 	return nil
 }
 
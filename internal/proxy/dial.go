@@ -0,0 +1,391 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrAuthFailed = errors.New("proxy authentication failed")
+	ErrRejected   = errors.New("proxy rejected request")
+)
+
+// ReplyError carries the failure code a proxy sent back: the SOCKS reply
+// field or the HTTP status of a CONNECT.
+type ReplyError struct {
+	Type   Type
+	Code   int
+	Detail string
+	Err    error
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("%s: %s: %s (code %d)", e.Type, e.Err, e.Detail, e.Code)
+}
+
+func (e *ReplyError) Unwrap() error { return e.Err }
+
+type Stage string
+
+const (
+	StageDial      Stage = "dial"
+	StageHandshake Stage = "handshake"
+	StageAuth      Stage = "auth"
+	StageConnect   Stage = "connect"
+	StageTarget    Stage = "target"
+)
+
+// StageError records how far a proxy session got before it failed.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string { return string(e.Stage) + ": " + e.Err.Error() }
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+func stageErr(stage Stage, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+func Dial(ctx context.Context, p Proxy, network, target string) (net.Conn, error) {
+	if err := checkNetwork(network); err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Address())
+	if err != nil {
+		return nil, stageErr(StageDial, err)
+	}
+	tunnel, err := Handshake(ctx, conn, p, target)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// DialFunc adapts Dial to the signature used by net/http transports.
+func DialFunc(p Proxy) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return Dial(ctx, p, network, addr)
+	}
+}
+
+func Handshake(ctx context.Context, conn net.Conn, p Proxy, target string) (net.Conn, error) {
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+		defer conn.SetDeadline(time.Time{})
+	}
+	tunnel, err := negotiate(ctx, conn, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := connectRequest(tunnel, p, target); err != nil {
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// negotiate runs everything that happens before the CONNECT request: TLS
+// for https proxies, method selection and sub-negotiation for SOCKS5.
+func negotiate(ctx context.Context, conn net.Conn, p Proxy) (net.Conn, error) {
+	switch p.Type {
+	case TypeHTTP, TypeSOCKS4:
+		return conn, nil
+	case TypeHTTPS:
+		tc := tls.Client(conn, &tls.Config{ServerName: p.Host, InsecureSkipVerify: p.Insecure})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, stageErr(StageHandshake, err)
+		}
+		return tc, nil
+	case TypeSOCKS5:
+		if err := socks5Negotiate(conn, p); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	default:
+		return nil, stageErr(StageHandshake, fmt.Errorf("unsupported proxy type: %s", p.Type))
+	}
+}
+
+func connectRequest(conn net.Conn, p Proxy, target string) error {
+	switch p.Type {
+	case TypeHTTP, TypeHTTPS:
+		return httpConnect(conn, p, target)
+	case TypeSOCKS4:
+		return socks4Connect(conn, p, target)
+	case TypeSOCKS5:
+		req, err := socks5Request(0x01, target)
+		if err != nil {
+			return stageErr(StageConnect, err)
+		}
+		if _, err := conn.Write(req); err != nil {
+			return stageErr(StageConnect, err)
+		}
+		return stageErr(StageConnect, socks5ReadReply(conn, p))
+	default:
+		return stageErr(StageConnect, fmt.Errorf("unsupported proxy type: %s", p.Type))
+	}
+}
+
+func checkNetwork(network string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return nil
+	default:
+		return fmt.Errorf("unsupported network: %s", network)
+	}
+}
+
+func hasCredentials(p Proxy) bool {
+	return p.Auth == AuthBasic && p.User != ""
+}
+
+// proxyAuthorization is the Proxy-Authorization header line for p, if it
+// has credentials.
+func proxyAuthorization(p Proxy) string {
+	if !hasCredentials(p) {
+		return ""
+	}
+	cred := base64.StdEncoding.EncodeToString([]byte(p.User + ":" + p.Pass))
+	return "Proxy-Authorization: Basic " + cred + "\r\n"
+}
+
+func httpConnect(conn net.Conn, p Proxy, target string) error {
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n" + proxyAuthorization(p) + "\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		return stageErr(StageConnect, err)
+	}
+	resp, err := readResponse(conn)
+	if err != nil {
+		return stageErr(StageConnect, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return stageErr(StageAuth, &ReplyError{Type: p.Type, Code: resp.StatusCode, Detail: http.StatusText(resp.StatusCode), Err: ErrAuthFailed})
+	}
+	return stageErr(StageConnect, &ReplyError{Type: p.Type, Code: resp.StatusCode, Detail: http.StatusText(resp.StatusCode), Err: ErrRejected})
+}
+
+func readResponse(conn net.Conn) (*http.Response, error) {
+	// read byte by byte so no tunnel payload is consumed by a buffer
+	return http.ReadResponse(bufio.NewReaderSize(byteReader{conn}, 16), nil)
+}
+
+var socks4Replies = map[byte]string{
+	0x5b: "request rejected or failed",
+	0x5c: "client identd unreachable",
+	0x5d: "identd user mismatch",
+}
+
+func socks4Connect(conn net.Conn, p Proxy, target string) error {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return stageErr(StageConnect, err)
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil && net.ParseIP(host) != nil {
+		return stageErr(StageConnect, errors.New("socks4: IPv6 targets are not supported"))
+	}
+	return socks4Request(conn, p, ip, host, port)
+}
+
+func socks4Request(conn net.Conn, p Proxy, ip net.IP, host string, port int) error {
+	req := []byte{0x04, 0x01}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	socks4a := ip == nil
+	if socks4a {
+		// SOCKS4a: an invalid 0.0.0.x address tells the proxy to resolve
+		// the host name appended after the user id
+		ip = net.IPv4(0, 0, 0, 1).To4()
+	}
+	req = append(req, ip...)
+	req = append(req, p.User...)
+	req = append(req, 0x00)
+	if socks4a {
+		req = append(req, host...)
+		req = append(req, 0x00)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return stageErr(StageConnect, err)
+	}
+
+	var reply [8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return stageErr(StageConnect, err)
+	}
+	if reply[0] != 0x00 {
+		return stageErr(StageHandshake, errors.New("socks4: invalid reply version"))
+	}
+	if reply[1] == 0x5a {
+		return nil
+	}
+	detail, ok := socks4Replies[reply[1]]
+	if !ok {
+		detail = "unknown reply"
+	}
+	if reply[1] == 0x5c || reply[1] == 0x5d {
+		return stageErr(StageAuth, &ReplyError{Type: p.Type, Code: int(reply[1]), Detail: detail, Err: ErrAuthFailed})
+	}
+	return stageErr(StageConnect, &ReplyError{Type: p.Type, Code: int(reply[1]), Detail: detail, Err: ErrRejected})
+}
+
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+func socks5Negotiate(conn net.Conn, p Proxy) error {
+	greet := []byte{0x05, 0x01, 0x00}
+	if hasCredentials(p) {
+		greet = []byte{0x05, 0x02, 0x00, 0x02}
+	}
+	if _, err := conn.Write(greet); err != nil {
+		return stageErr(StageHandshake, err)
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil {
+		return stageErr(StageHandshake, err)
+	}
+	if method[0] != 0x05 {
+		return stageErr(StageHandshake, errors.New("socks5: invalid reply version"))
+	}
+	switch method[1] {
+	case 0x00:
+		return nil
+	case 0x02:
+		if !hasCredentials(p) {
+			return stageErr(StageAuth, &ReplyError{Type: p.Type, Code: int(method[1]), Detail: "credentials required", Err: ErrAuthFailed})
+		}
+		return stageErr(StageAuth, socks5Authenticate(conn, p))
+	default:
+		return stageErr(StageAuth, &ReplyError{Type: p.Type, Code: int(method[1]), Detail: "no acceptable auth method", Err: ErrAuthFailed})
+	}
+}
+
+// RFC 1929 username/password sub-negotiation.
+func socks5Authenticate(conn net.Conn, p Proxy) error {
+	if len(p.User) > 255 || len(p.Pass) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+	req := []byte{0x01, byte(len(p.User))}
+	req = append(req, p.User...)
+	req = append(req, byte(len(p.Pass)))
+	req = append(req, p.Pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return &ReplyError{Type: p.Type, Code: int(reply[1]), Detail: "username/password rejected", Err: ErrAuthFailed}
+	}
+	return nil
+}
+
+func socks5Request(cmd byte, target string) ([]byte, error) {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	req := []byte{0x05, cmd, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("socks5: host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(port)), nil
+}
+
+func socks5ReadReply(conn net.Conn, p Proxy) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != 0x05 {
+		return errors.New("socks5: invalid reply version")
+	}
+	if hdr[1] != 0x00 {
+		detail, ok := socks5Replies[hdr[1]]
+		if !ok {
+			detail = "unknown reply"
+		}
+		return &ReplyError{Type: p.Type, Code: int(hdr[1]), Detail: detail, Err: ErrRejected}
+	}
+
+	var skip int
+	switch hdr[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return errors.New("socks5: invalid bind address type")
+	}
+	// bound address + port are not needed by callers
+	_, err := io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+func splitTarget(target string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 0xffff {
+		return "", 0, errors.New("invalid port")
+	}
+	return host, port, nil
+}
+
+type byteReader struct{ r io.Reader }
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return b.r.Read(p[:1])
+}
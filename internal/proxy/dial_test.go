@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeServer plays the proxy side of a handshake on one end of a pipe and
+// returns the target it was asked for, or an error if the client's request
+// was malformed.
+type fakeServer func(conn net.Conn) (string, error)
+
+func readCString(r io.Reader) (string, error) {
+	var out []byte
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(out), nil
+		}
+		out = append(out, b[0])
+	}
+}
+
+// socks4Server answers with reply and accepts only user as user id.
+func socks4Server(user string, reply byte) fakeServer {
+	return func(conn net.Conn) (string, error) {
+		var hdr [8]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return "", err
+		}
+		if hdr[0] != 0x04 || hdr[1] != 0x01 {
+			return "", fmt.Errorf("bad request header % x", hdr[:2])
+		}
+		id, err := readCString(conn)
+		if err != nil {
+			return "", err
+		}
+		port := binary.BigEndian.Uint16(hdr[2:4])
+		host := net.IP(hdr[4:8]).String()
+		if hdr[4] == 0 && hdr[5] == 0 && hdr[6] == 0 && hdr[7] != 0 {
+			if host, err = readCString(conn); err != nil {
+				return "", err
+			}
+		}
+		code := reply
+		if id != user {
+			code = 0x5d
+		}
+		_, _ = conn.Write([]byte{0x00, code, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(host, fmt.Sprint(port)), nil
+	}
+}
+
+// socks5Server requires RFC 1929 auth when user is set and answers the
+// CONNECT request with reply.
+func socks5Server(user, pass string, reply byte) fakeServer {
+	return func(conn net.Conn) (string, error) {
+		var greet [2]byte
+		if _, err := io.ReadFull(conn, greet[:]); err != nil {
+			return "", err
+		}
+		methods := make([]byte, greet[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return "", err
+		}
+		want := byte(0x00)
+		if user != "" {
+			want = 0x02
+		}
+		offered := false
+		for _, m := range methods {
+			offered = offered || m == want
+		}
+		if !offered {
+			_, _ = conn.Write([]byte{0x05, 0xff})
+			return "", nil
+		}
+		_, _ = conn.Write([]byte{0x05, want})
+		if want == 0x02 {
+			var ver [2]byte
+			if _, err := io.ReadFull(conn, ver[:]); err != nil {
+				return "", err
+			}
+			u := make([]byte, ver[1])
+			_, _ = io.ReadFull(conn, u)
+			var pl [1]byte
+			_, _ = io.ReadFull(conn, pl[:])
+			pw := make([]byte, pl[0])
+			_, _ = io.ReadFull(conn, pw)
+			if string(u) != user || string(pw) != pass {
+				_, _ = conn.Write([]byte{0x01, 0x01})
+				return "", nil
+			}
+			_, _ = conn.Write([]byte{0x01, 0x00})
+		}
+
+		var req [4]byte
+		if _, err := io.ReadFull(conn, req[:]); err != nil {
+			return "", err
+		}
+		var host string
+		switch req[3] {
+		case 0x01:
+			ip := make([]byte, 4)
+			_, _ = io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			var l [1]byte
+			_, _ = io.ReadFull(conn, l[:])
+			name := make([]byte, l[0])
+			_, _ = io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			return "", fmt.Errorf("unexpected address type %d", req[3])
+		}
+		var port [2]byte
+		_, _ = io.ReadFull(conn, port[:])
+		_, _ = conn.Write([]byte{0x05, reply, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port[:]))), nil
+	}
+}
+
+// connectServer answers CONNECT with status, or 407 when user is set and
+// the request lacks matching credentials.
+func connectServer(user, pass string, status int) fakeServer {
+	return func(conn net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return "", err
+		}
+		if req.Method != http.MethodConnect {
+			return "", fmt.Errorf("method %s", req.Method)
+		}
+		code := status
+		if user != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pass)) {
+			code = http.StatusProxyAuthRequired
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
+		return req.Host, nil
+	}
+}
+
+// tlsServer wraps next in TLS with the certificate of an httptest server.
+func tlsServer(t *testing.T, next fakeServer) fakeServer {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	cfg := srv.TLS.Clone()
+	return func(conn net.Conn) (string, error) {
+		tc := tls.Server(conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return "", err
+		}
+		return next(tc)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		p      Proxy
+		server fakeServer
+		target string
+		stage  Stage
+		err    error
+		code   int
+	}{
+		{name: "socks4 ip", p: Proxy{Type: TypeSOCKS4, User: "op"}, server: socks4Server("op", 0x5a), target: "10.10.10.5:80"},
+		{name: "socks4a host name", p: Proxy{Type: TypeSOCKS4}, server: socks4Server("", 0x5a), target: "box.htb:443"},
+		{name: "socks4 rejected", p: Proxy{Type: TypeSOCKS4}, server: socks4Server("", 0x5b), target: "10.10.10.5:80",
+			stage: StageConnect, err: ErrRejected, code: 0x5b},
+		{name: "socks4 user id refused", p: Proxy{Type: TypeSOCKS4, User: "guest"}, server: socks4Server("op", 0x5a), target: "10.10.10.5:80",
+			stage: StageAuth, err: ErrAuthFailed, code: 0x5d},
+
+		{name: "socks5 no auth", p: Proxy{Type: TypeSOCKS5}, server: socks5Server("", "", 0x00), target: "box.htb:22"},
+		{name: "socks5 rfc1929", p: Proxy{Type: TypeSOCKS5, Auth: AuthBasic, User: "op", Pass: "secret"}, server: socks5Server("op", "secret", 0x00), target: "10.10.10.5:445"},
+		{name: "socks5 wrong password", p: Proxy{Type: TypeSOCKS5, Auth: AuthBasic, User: "op", Pass: "guess"}, server: socks5Server("op", "secret", 0x00), target: "10.10.10.5:445",
+			stage: StageAuth, err: ErrAuthFailed, code: 0x01},
+		{name: "socks5 credentials required", p: Proxy{Type: TypeSOCKS5}, server: socks5Server("op", "secret", 0x00), target: "10.10.10.5:445",
+			stage: StageAuth, err: ErrAuthFailed, code: 0xff},
+		{name: "socks5 connection refused", p: Proxy{Type: TypeSOCKS5}, server: socks5Server("", "", 0x05), target: "10.10.10.5:445",
+			stage: StageConnect, err: ErrRejected, code: 0x05},
+
+		{name: "http connect", p: Proxy{Type: TypeHTTP}, server: connectServer("", "", 200), target: "box.htb:443"},
+		{name: "http connect basic auth", p: Proxy{Type: TypeHTTP, Auth: AuthBasic, User: "op", Pass: "secret"}, server: connectServer("op", "secret", 200), target: "box.htb:443"},
+		{name: "http connect wrong password", p: Proxy{Type: TypeHTTP, Auth: AuthBasic, User: "op", Pass: "guess"}, server: connectServer("op", "secret", 200), target: "box.htb:443",
+			stage: StageAuth, err: ErrAuthFailed, code: 407},
+		{name: "http connect forbidden", p: Proxy{Type: TypeHTTP}, server: connectServer("", "", 403), target: "box.htb:443",
+			stage: StageConnect, err: ErrRejected, code: 403},
+
+		{name: "https connect", p: Proxy{Type: TypeHTTPS, Host: "proxy.lab", Insecure: true, Auth: AuthBasic, User: "op", Pass: "secret"},
+			server: tlsServer(t, connectServer("op", "secret", 200)), target: "box.htb:443"},
+		{name: "https untrusted certificate", p: Proxy{Type: TypeHTTPS, Host: "proxy.lab"},
+			server: tlsServer(t, connectServer("", "", 200)), target: "box.htb:443", stage: StageHandshake},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			type served struct {
+				target string
+				err    error
+			}
+			done := make(chan served, 1)
+			go func() {
+				defer server.Close()
+				target, err := tt.server(server)
+				done <- served{target, err}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err := Handshake(ctx, client, tt.p, tt.target)
+			_ = client.Close()
+			got := <-done
+
+			if tt.stage == "" {
+				if err != nil {
+					t.Fatalf("handshake: %v", err)
+				}
+				if got.err != nil {
+					t.Fatalf("server: %v", got.err)
+				}
+				if got.target != tt.target {
+					t.Errorf("server saw target %q, want %q", got.target, tt.target)
+				}
+				return
+			}
+
+			var se *StageError
+			if !errors.As(err, &se) || se.Stage != tt.stage {
+				t.Fatalf("got %v, want stage %s", err, tt.stage)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			var re *ReplyError
+			if tt.code != 0 && (!errors.As(err, &re) || re.Code != tt.code) {
+				t.Errorf("got %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestDialRejectsUDP(t *testing.T) {
+	if _, err := Dial(context.Background(), Proxy{Type: TypeSOCKS5, Host: "127.0.0.1", Port: 1}, "udp", "10.10.10.5:53"); err == nil {
+		t.Error("udp dial accepted")
+	}
+}
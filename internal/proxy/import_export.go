@@ -55,7 +55,11 @@ func FormatProxyLine(p Proxy) string {
 
 	userInfo := ""
 	if p.Auth == AuthBasic && p.User != "" {
-		userInfo = url.UserPassword(p.User, p.Pass).String() + "@"
+		if p.Pass == "" {
+			userInfo = url.User(p.User).String() + "@"
+		} else {
+			userInfo = url.UserPassword(p.User, p.Pass).String() + "@"
+		}
 	}
 
 	name := ""
@@ -11,6 +11,7 @@ type Manager struct {
 	byID       map[string]Proxy
 	byName     map[string]string
 	activeName string
+	onChange   func()
 }
 
 func NewManager() *Manager {
@@ -20,6 +21,21 @@ func NewManager() *Manager {
 	}
 }
 
+func (m *Manager) SetOnChange(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = fn
+}
+
+func (m *Manager) changed() {
+	m.mu.RLock()
+	fn := m.onChange
+	m.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (m *Manager) Add(p Proxy) error {
 	if p.Name == "" {
 		return errors.New("proxy name required")
@@ -32,8 +48,8 @@ func (m *Manager) Add(p Proxy) error {
 	}
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	if _, exists := m.byName[p.Name]; exists {
+		m.mu.Unlock()
 		return errors.New("proxy name already exists")
 	}
 	if p.ID == "" {
@@ -44,10 +60,20 @@ func (m *Manager) Add(p Proxy) error {
 	if m.activeName == "" {
 		m.activeName = p.Name
 	}
+	m.mu.Unlock()
+	m.changed()
 	return nil
 }
 
 func (m *Manager) Update(id string, p Proxy) error {
+	if err := m.update(id, p); err != nil {
+		return err
+	}
+	m.changed()
+	return nil
+}
+
+func (m *Manager) update(id string, p Proxy) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	old, ok := m.byID[id]
@@ -82,9 +108,9 @@ func (m *Manager) Update(id string, p Proxy) error {
 
 func (m *Manager) Remove(id string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	p, ok := m.byID[id]
 	if !ok {
+		m.mu.Unlock()
 		return errors.New("proxy not found")
 	}
 	delete(m.byID, id)
@@ -96,6 +122,8 @@ func (m *Manager) Remove(id string) error {
 			break
 		}
 	}
+	m.mu.Unlock()
+	m.changed()
 	return nil
 }
 
@@ -145,15 +173,17 @@ func (m *Manager) GetActive() (Proxy, bool) {
 }
 
 func (m *Manager) SetActive(name string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
 	if name == "" {
 		return errors.New("proxy name required")
 	}
+	m.mu.Lock()
 	if _, ok := m.byName[name]; !ok {
+		m.mu.Unlock()
 		return errors.New("proxy not found")
 	}
 	m.activeName = name
+	m.mu.Unlock()
+	m.changed()
 	return nil
 }
 
@@ -3,25 +3,76 @@ package proxy
 import (
 	"errors"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/lily0ng/RootProxy/internal/config"
 )
 
+var ErrAllUnhealthy = errors.New("all proxies in profile chain are unhealthy")
+
+// ErrRandomPick is returned by Peek when the next pick is a random draw.
+var ErrRandomPick = errors.New("next proxy is picked at random")
+
+const DefaultMaxFailures = 1
+
+// Health is what rotation needs to know about a proxy's recent tests.
+type Health struct {
+	LastOK              bool
+	ConsecutiveFailures int
+	LastTestAt          time.Time
+	LastLatency         time.Duration
+	Failures            uint64
+}
+
+type HealthSource interface {
+	Health(name string) (Health, bool)
+}
+
 type Rotator struct {
-	mu        sync.Mutex
-	positions map[string]int
-	rnd       *rand.Rand
+	mu sync.Mutex
+	// positions is the round robin cursor of Rotate per profile;
+	// connPositions the one of Select, so per-connection picks do not
+	// skip scheduled rotations.
+	positions     map[string]int
+	connPositions map[string]int
+	rnd           *rand.Rand
+	health        HealthSource
+	probe         func(Proxy) TestResult
+	// affinity maps profile -> target host -> assigned proxy.
+	affinity  map[string]map[string]affinityEntry
+	lastSweep time.Time
+	now       func() time.Time
 }
 
 func NewRotator() *Rotator {
 	return &Rotator{
-		positions: make(map[string]int),
-		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		positions:     make(map[string]int),
+		connPositions: make(map[string]int),
+		rnd:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		affinity:      make(map[string]map[string]affinityEntry),
+		now:           time.Now,
 	}
 }
 
+// SetHealth sets where health-checked rotation reads test results from and
+// how it retests a proxy when the policy asks for it.
+func (r *Rotator) SetHealth(src HealthSource, probe func(Proxy) TestResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.health = src
+	r.probe = probe
+}
+
+// SetRandSource replaces the random source used by the random and weighted
+// modes, so selections can be reproduced.
+func (r *Rotator) SetRandSource(src rand.Source) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rnd = rand.New(src)
+}
+
 func (r *Rotator) Rotate(profileName string, chain []string, policy config.RotationPolicy, mgr *Manager) (string, error) {
 	if mgr == nil {
 		return "", errors.New("proxy manager required")
@@ -29,40 +80,223 @@ func (r *Rotator) Rotate(profileName string, chain []string, policy config.Rotat
 	if !policy.Enabled || policy.Mode == config.RotationOff {
 		return "", errors.New("rotation disabled")
 	}
+	p, err := r.pick(profileName, chain, policy, mgr, r.positions)
+	if err != nil {
+		return "", err
+	}
+	if err := mgr.SetActive(p.Name); err != nil {
+		return "", err
+	}
+	return p.Name, nil
+}
+
+// Select picks the next proxy of chain for profileName like Rotate does,
+// but leaves the manager's active proxy alone, so callers can spread
+// individual connections over the chain. It keeps its own position, apart
+// from Rotate's. A policy without a mode selects round robin.
+func (r *Rotator) Select(profileName string, chain []string, policy config.RotationPolicy, mgr *Manager) (Proxy, error) {
+	if mgr == nil {
+		return Proxy{}, errors.New("proxy manager required")
+	}
+	return r.pick(profileName, chain, selectPolicy(policy), mgr, r.connPositions)
+}
+
+// Peek reports what Select would pick next without advancing its position
+// or retesting anything; health is judged from recorded results only.
+// The random and weighted modes draw on every pick, so for them Peek only
+// checks that a healthy proxy exists and returns ErrRandomPick.
+func (r *Rotator) Peek(profileName string, chain []string, policy config.RotationPolicy, mgr *Manager) (Proxy, error) {
+	if mgr == nil {
+		return Proxy{}, errors.New("proxy manager required")
+	}
+	policy = selectPolicy(policy)
+	// a separate source keeps previews from changing later random picks
+	valid, order, err := r.order(profileName, chain, policy, mgr, r.connPositions,
+		rand.New(rand.NewSource(1)))
+	if err != nil {
+		return Proxy{}, err
+	}
+	r.mu.Lock()
+	health := r.health
+	r.mu.Unlock()
+	for _, idx := range order {
+		if !r.stillHealthy(valid[idx], policy, health) {
+			continue
+		}
+		if policy.Mode == config.RotationRandom || policy.Mode == config.RotationWeighted {
+			return Proxy{}, ErrRandomPick
+		}
+		return valid[idx], nil
+	}
+	return Proxy{}, ErrAllUnhealthy
+}
+
+func selectPolicy(policy config.RotationPolicy) config.RotationPolicy {
+	if policy.Mode == "" || policy.Mode == config.RotationOff {
+		policy.Mode = config.RotationRoundRobin
+	}
+	return policy
+}
+
+// pick chooses the next proxy of chain under policy and advances the
+// profile's entry in positions, without touching the manager's active
+// proxy.
+func (r *Rotator) pick(profileName string, chain []string, policy config.RotationPolicy, mgr *Manager, positions map[string]int) (Proxy, error) {
+	valid, order, err := r.order(profileName, chain, policy, mgr, positions, nil)
+	if err != nil {
+		return Proxy{}, err
+	}
+	// health checks (and retests) run without holding r.mu
+	r.mu.Lock()
+	health, probe := r.health, r.probe
+	r.mu.Unlock()
+
+	for _, idx := range order {
+		p := valid[idx]
+		if policy.HealthChecked() && !r.healthy(p, policy, health, probe) {
+			continue
+		}
+		r.mu.Lock()
+		positions[profileName] = (idx + 1) % len(valid)
+		r.mu.Unlock()
+		return p, nil
+	}
+	return Proxy{}, ErrAllUnhealthy
+}
+
+// order lists the existing proxies of chain and the order to try them in,
+// starting from the profile's entry in positions for round robin. A nil
+// rnd uses the rotator's own source.
+func (r *Rotator) order(profileName string, chain []string, policy config.RotationPolicy, mgr *Manager, positions map[string]int, rnd *rand.Rand) ([]Proxy, []int, error) {
 	if len(chain) == 0 {
-		return "", errors.New("profile chain is empty")
+		return nil, nil, errors.New("profile chain is empty")
 	}
 
 	// Only consider proxies that exist
-	valid := make([]string, 0, len(chain))
+	valid := make([]Proxy, 0, len(chain))
 	for _, name := range chain {
-		if _, ok := mgr.GetByName(name); ok {
-			valid = append(valid, name)
+		if p, ok := mgr.GetByName(name); ok {
+			valid = append(valid, p)
 		}
 	}
 	if len(valid) == 0 {
-		return "", errors.New("no valid proxies in chain")
+		return nil, nil, errors.New("no valid proxies in chain")
 	}
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
-
+	if rnd == nil {
+		rnd = r.rnd
+	}
+	var order []int
 	switch policy.Mode {
-	case config.RotationRoundRobin:
-		idx := r.positions[profileName] % len(valid)
-		chosen := valid[idx]
-		r.positions[profileName] = (idx + 1) % len(valid)
-		if err := mgr.SetActive(chosen); err != nil {
-			return "", err
+	case config.RotationRoundRobin, config.RotationHealthy:
+		start := positions[profileName] % len(valid)
+		for i := range valid {
+			order = append(order, (start+i)%len(valid))
 		}
-		return chosen, nil
 	case config.RotationRandom:
-		chosen := valid[r.rnd.Intn(len(valid))]
-		if err := mgr.SetActive(chosen); err != nil {
-			return "", err
-		}
-		return chosen, nil
+		order = rnd.Perm(len(valid))
+	case config.RotationWeighted:
+		order = weightedOrder(valid, rnd)
+	case config.RotationLowestLatency, config.RotationLeastFailures:
+		order = rankedOrder(valid, policy.Mode, r.health)
 	default:
-		return "", errors.New("unsupported rotation mode")
+		return nil, nil, errors.New("unsupported rotation mode")
+	}
+	return valid, order, nil
+}
+
+func (r *Rotator) healthy(p Proxy, policy config.RotationPolicy, src HealthSource, probe func(Proxy) TestResult) bool {
+	maxFailures := policy.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = DefaultMaxFailures
+	}
+	var h Health
+	known := false
+	if src != nil {
+		h, known = src.Health(p.Name)
+	}
+	stale := policy.MaxTestAge > 0 && (!known || time.Since(h.LastTestAt) > policy.MaxTestAge)
+
+	if policy.Retest && probe != nil {
+		// a fresh result overrides whatever was recorded before
+		return probe(p).OK
+	}
+	if stale {
+		return false
+	}
+	return !known || h.ConsecutiveFailures < maxFailures
+}
+
+// weightedOrder draws every index without replacement, each draw in
+// proportion to the remaining weights, so later entries are fallbacks.
+func weightedOrder(valid []Proxy, rnd *rand.Rand) []int {
+	left := make([]int, len(valid))
+	total := 0
+	for i, p := range valid {
+		left[i] = i
+		total += weightOf(p)
+	}
+	order := make([]int, 0, len(valid))
+	for len(left) > 0 {
+		n := rnd.Intn(total)
+		for k, idx := range left {
+			w := weightOf(valid[idx])
+			if n < w {
+				order = append(order, idx)
+				total -= w
+				left = append(left[:k], left[k+1:]...)
+				break
+			}
+			n -= w
+		}
+	}
+	return order
+}
+
+func weightOf(p Proxy) int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// rankedOrder sorts by last latency or by failure count. Proxies without a
+// successful test sort after measured ones for lowest_latency; ties keep
+// chain order.
+func rankedOrder(valid []Proxy, mode config.RotationMode, src HealthSource) []int {
+	type ranked struct {
+		idx      int
+		measured bool
+		latency  time.Duration
+		failures uint64
+	}
+	items := make([]ranked, len(valid))
+	for i, p := range valid {
+		items[i].idx = i
+		if src == nil {
+			continue
+		}
+		if h, ok := src.Health(p.Name); ok {
+			items[i].measured = h.LastOK && h.LastLatency > 0
+			items[i].latency = h.LastLatency
+			items[i].failures = h.Failures
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if mode == config.RotationLeastFailures {
+			return a.failures < b.failures
+		}
+		if a.measured != b.measured {
+			return a.measured
+		}
+		return a.measured && a.latency < b.latency
+	})
+	order := make([]int, len(items))
+	for i, it := range items {
+		order[i] = it.idx
 	}
+	return order
 }
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+)
+
+type fakeHealth map[string]Health
+
+func (f fakeHealth) Health(name string) (Health, bool) {
+	h, ok := f[name]
+	return h, ok
+}
+
+func newTestManager(t *testing.T, proxies ...Proxy) (*Manager, []string) {
+	t.Helper()
+	mgr := NewManager()
+	var names []string
+	for i, p := range proxies {
+		p.Type, p.Host, p.Port = TypeSOCKS5, "127.0.0.1", 1080+i
+		if err := mgr.Add(p); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, p.Name)
+	}
+	return mgr, names
+}
+
+func TestWeightedSelectionFollowsWeights(t *testing.T) {
+	mgr, chain := newTestManager(t,
+		Proxy{Name: "heavy", Weight: 6},
+		Proxy{Name: "medium", Weight: 3},
+		Proxy{Name: "light"},
+	)
+	r := NewRotator()
+	r.SetRandSource(rand.NewSource(42))
+	policy := config.RotationPolicy{Mode: config.RotationWeighted}
+
+	const n = 10000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		p, err := r.Select("lab", chain, policy, mgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[p.Name]++
+	}
+	for name, share := range map[string]float64{"heavy": 0.6, "medium": 0.3, "light": 0.1} {
+		got := float64(counts[name]) / n
+		if got < share-0.02 || got > share+0.02 {
+			t.Errorf("%s picked %.3f of the time, want %.2f", name, got, share)
+		}
+	}
+
+	// the same seed reproduces the same picks
+	replay := func() []string {
+		r := NewRotator()
+		r.SetRandSource(rand.NewSource(7))
+		var out []string
+		for i := 0; i < 20; i++ {
+			p, _ := r.Select("lab", chain, policy, mgr)
+			out = append(out, p.Name)
+		}
+		return out
+	}
+	a, b := replay(), replay()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("seeded selections differ at %d: %v vs %v", i, a, b)
+		}
+	}
+}
+
+func TestRankedOrder(t *testing.T) {
+	valid := []Proxy{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	health := fakeHealth{
+		"a": {LastOK: true, LastLatency: 300 * time.Millisecond, Failures: 2},
+		"b": {LastOK: false, LastLatency: 10 * time.Millisecond, Failures: 5},
+		"c": {LastOK: true, LastLatency: 50 * time.Millisecond, Failures: 0},
+		// d has never been tested
+	}
+	tests := []struct {
+		mode config.RotationMode
+		want []string
+	}{
+		// failed and untested proxies go last, in chain order
+		{config.RotationLowestLatency, []string{"c", "a", "b", "d"}},
+		// untested counts as no failures; ties keep chain order
+		{config.RotationLeastFailures, []string{"c", "d", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			order := rankedOrder(valid, tt.mode, health)
+			var got []string
+			for _, idx := range order {
+				got = append(got, valid[idx].Name)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("order = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectKeepsRotatePosition(t *testing.T) {
+	mgr, chain := newTestManager(t, Proxy{Name: "a"}, Proxy{Name: "b"}, Proxy{Name: "c"})
+	r := NewRotator()
+	policy := config.RotationPolicy{Enabled: true, Mode: config.RotationRoundRobin}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Select("lab", chain, policy, mgr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peek, _ := r.Peek("lab", chain, policy, mgr)
+	next, _ := r.Select("lab", chain, policy, mgr)
+	if peek.Name != next.Name {
+		t.Errorf("peek = %s, select = %s", peek.Name, next.Name)
+	}
+	if got, _ := r.Rotate("lab", chain, policy, mgr); got != "a" {
+		t.Errorf("rotate after connection picks = %s, want a", got)
+	}
+}
+
+func TestPeekRandomModes(t *testing.T) {
+	mgr, chain := newTestManager(t, Proxy{Name: "a"}, Proxy{Name: "b"})
+	r := NewRotator()
+	for _, mode := range []config.RotationMode{config.RotationRandom, config.RotationWeighted} {
+		if _, err := r.Peek("lab", chain, config.RotationPolicy{Mode: mode}, mgr); !errors.Is(err, ErrRandomPick) {
+			t.Errorf("%s: peek = %v, want ErrRandomPick", mode, err)
+		}
+	}
+
+	r.SetHealth(fakeHealth{"a": {ConsecutiveFailures: 1}, "b": {ConsecutiveFailures: 1}}, nil)
+	policy := config.RotationPolicy{Mode: config.RotationRandom, SkipUnhealthy: true}
+	if _, err := r.Peek("lab", chain, policy, mgr); !errors.Is(err, ErrAllUnhealthy) {
+		t.Errorf("peek over unhealthy proxies = %v, want ErrAllUnhealthy", err)
+	}
+}
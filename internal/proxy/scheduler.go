@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+)
+
+const maxRotationEvents = 100
+
+type RotationEvent struct {
+	Profile string    `json:"profile"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Manual  bool      `json:"manual"`
+	At      time.Time `json:"at"`
+}
+
+type ScheduleStatus struct {
+	Profile   string              `json:"profile"`
+	Mode      config.RotationMode `json:"mode"`
+	Interval  string              `json:"interval"`
+	Paused    bool                `json:"paused"`
+	NextAt    time.Time           `json:"next_at"`
+	LastError string              `json:"last_error,omitempty"`
+}
+
+type scheduleJob struct {
+	profile config.Profile
+	stop    chan struct{}
+	nextAt  time.Time
+	lastErr string
+}
+
+// Scheduler rotates the active proxy of every profile whose rotation policy
+// has an interval. A profile's schedule only fires while it is the active
+// profile, since all profiles share the single active proxy.
+type Scheduler struct {
+	rot    *Rotator
+	mgr    *Manager
+	active func() string
+
+	mu       sync.Mutex
+	jobs     map[string]*scheduleJob
+	paused   map[string]bool
+	events   []RotationEvent
+	onRotate func(RotationEvent)
+}
+
+func NewScheduler(rot *Rotator, mgr *Manager, activeProfile func() string) *Scheduler {
+	return &Scheduler{
+		rot:    rot,
+		mgr:    mgr,
+		active: activeProfile,
+		jobs:   make(map[string]*scheduleJob),
+		paused: make(map[string]bool),
+	}
+}
+
+func (s *Scheduler) SetOnRotate(fn func(RotationEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRotate = fn
+}
+
+// Sync starts, restarts or stops schedules so they match profiles.
+func (s *Scheduler) Sync(profiles []config.Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := make(map[string]config.Profile)
+	for _, p := range profiles {
+		if p.Rotation.Scheduled() {
+			want[p.Name] = p
+		}
+	}
+	for name, j := range s.jobs {
+		p, ok := want[name]
+		if ok && sameSchedule(j.profile, p) {
+			delete(want, name)
+			continue
+		}
+		close(j.stop)
+		delete(s.jobs, name)
+	}
+	for name, p := range want {
+		j := &scheduleJob{
+			profile: p,
+			stop:    make(chan struct{}),
+			nextAt:  time.Now().Add(p.Rotation.Interval),
+		}
+		s.jobs[name] = j
+		go s.run(j)
+	}
+}
+
+func sameSchedule(a, b config.Profile) bool {
+	if a.Rotation != b.Rotation || len(a.Chain) != len(b.Chain) {
+		return false
+	}
+	for i := range a.Chain {
+		if a.Chain[i] != b.Chain[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scheduler) run(j *scheduleJob) {
+	t := time.NewTicker(j.profile.Rotation.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			j.nextAt = now.Add(j.profile.Rotation.Interval)
+			paused := s.paused[j.profile.Name]
+			s.mu.Unlock()
+			if paused || s.active() != j.profile.Name {
+				continue
+			}
+			_, err := s.rotate(j.profile.Name, j.profile.Chain, j.profile.Rotation, false)
+			s.mu.Lock()
+			j.lastErr = ""
+			if err != nil {
+				j.lastErr = err.Error()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Rotate rotates profileName immediately and records an event if the
+// active proxy changed.
+func (s *Scheduler) Rotate(profileName string, chain []string, policy config.RotationPolicy) (string, error) {
+	return s.rotate(profileName, chain, policy, true)
+}
+
+func (s *Scheduler) rotate(profileName string, chain []string, policy config.RotationPolicy, manual bool) (string, error) {
+	from := s.mgr.ActiveName()
+	to, err := s.rot.Rotate(profileName, chain, policy, s.mgr)
+	if err != nil {
+		return "", err
+	}
+	if to == from {
+		return to, nil
+	}
+
+	ev := RotationEvent{Profile: profileName, From: from, To: to, Manual: manual, At: time.Now().UTC()}
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	if len(s.events) > maxRotationEvents {
+		s.events = append([]RotationEvent(nil), s.events[len(s.events)-maxRotationEvents:]...)
+	}
+	fn := s.onRotate
+	s.mu.Unlock()
+	if fn != nil {
+		fn(ev)
+	}
+	return to, nil
+}
+
+func (s *Scheduler) Pause(profileName string) error {
+	return s.setPaused(profileName, true)
+}
+
+func (s *Scheduler) Resume(profileName string) error {
+	return s.setPaused(profileName, false)
+}
+
+func (s *Scheduler) setPaused(profileName string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[profileName]; !ok {
+		return errors.New("profile has no rotation schedule")
+	}
+	if paused {
+		s.paused[profileName] = true
+	} else {
+		delete(s.paused, profileName)
+	}
+	return nil
+}
+
+func (s *Scheduler) Paused(profileName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[profileName]
+}
+
+func (s *Scheduler) Status() []ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduleStatus, 0, len(s.jobs))
+	for name, j := range s.jobs {
+		out = append(out, ScheduleStatus{
+			Profile:   name,
+			Mode:      j.profile.Rotation.Mode,
+			Interval:  j.profile.Rotation.Interval.String(),
+			Paused:    s.paused[name],
+			NextAt:    j.nextAt,
+			LastError: j.lastErr,
+		})
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Profile < out[k].Profile })
+	return out
+}
+
+// Events returns the most recent active proxy changes, oldest first.
+func (s *Scheduler) Events() []RotationEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RotationEvent(nil), s.events...)
+}
+
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, j := range s.jobs {
+		close(j.stop)
+		delete(s.jobs, name)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultSpeedTestBytes = 10 << 20
+
+type SpeedTestOptions struct {
+	// URL is downloaded through the tunnel; "{bytes}" in it is replaced by
+	// Bytes so endpoints that take a size serve exactly that much.
+	URL string
+	// Bytes caps the download (default DefaultSpeedTestBytes).
+	Bytes int64
+}
+
+type SpeedTestResult struct {
+	OK    bool
+	Bytes int64
+	// TTFB runs from the start of the dial to the first body byte.
+	TTFB     time.Duration
+	Duration time.Duration
+	// BytesPerSec is measured over the transfer after the first chunk read,
+	// or over the whole Duration when everything came in that chunk.
+	BytesPerSec float64
+	Error       string
+}
+
+// SpeedTest downloads opts.URL over connections made by dial, which may go
+// through a single proxy (DialFunc) or a chain (DialHops).
+func SpeedTest(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), opts SpeedTestOptions) SpeedTestResult {
+	limit := opts.Bytes
+	if limit <= 0 {
+		limit = DefaultSpeedTestBytes
+	}
+	target := strings.ReplaceAll(opts.URL, "{bytes}", strconv.FormatInt(limit, 10))
+	if target == "" {
+		return SpeedTestResult{Error: "speed test url required"}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return SpeedTestResult{Error: err.Error()}
+	}
+	tr := &http.Transport{DialContext: dial, DisableKeepAlives: true, DisableCompression: true}
+	defer tr.CloseIdleConnections()
+
+	start := time.Now()
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return SpeedTestResult{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return SpeedTestResult{Error: fmt.Sprintf("speed test target returned %s", resp.Status)}
+	}
+
+	var res SpeedTestResult
+	var first int64
+	buf := make([]byte, 32<<10)
+	for res.Bytes < limit {
+		chunk := buf
+		if left := limit - res.Bytes; left < int64(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		n, err := resp.Body.Read(chunk)
+		if n > 0 && res.Bytes == 0 {
+			res.TTFB = time.Since(start)
+			first = int64(n)
+		}
+		res.Bytes += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			res.Error = err.Error()
+			break
+		}
+	}
+	res.Duration = time.Since(start)
+	// the first chunk arrived by TTFB, so it is not part of the transfer
+	if transfer := res.Duration - res.TTFB; res.Bytes > first && transfer > 0 {
+		res.BytesPerSec = float64(res.Bytes-first) / transfer.Seconds()
+	} else if res.Bytes > 0 && res.Duration > 0 {
+		res.BytesPerSec = float64(res.Bytes) / res.Duration.Seconds()
+	}
+	res.OK = res.Error == "" && res.Bytes > 0
+	if res.Error == "" && res.Bytes == 0 {
+		res.Error = "speed test target sent no data"
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpeedTestRateExcludesFirstChunk(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 1000)))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(strings.Repeat("b", 1000)))
+	}))
+	defer srv.Close()
+
+	var d net.Dialer
+	res := SpeedTest(context.Background(), d.DialContext, SpeedTestOptions{URL: srv.URL})
+	if !res.OK || res.Bytes != 2000 {
+		t.Fatalf("result = %+v", res)
+	}
+	// 1000 bytes over the ~200ms after the first chunk; counting the first
+	// chunk as well would double it
+	if res.BytesPerSec > 6000 || res.BytesPerSec < 1000 {
+		t.Errorf("rate = %.0f B/s, want about 5000", res.BytesPerSec)
+	}
+}
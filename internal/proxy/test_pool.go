@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTestConcurrency = 8
+	DefaultTestTimeout     = 3 * time.Second
+)
+
+type PoolOptions struct {
+	Concurrency int
+	// Timeout applies to each proxy's test.
+	Timeout time.Duration
+	Test    TestOptions
+	// Prepare runs before each test, e.g. to fill in secrets; an error
+	// fails that proxy's test at the auth stage.
+	Prepare func(Proxy) (Proxy, error)
+}
+
+// TestMany tests items on a bounded worker pool and calls fn with each
+// result as it completes. fn is called from the calling goroutine, one
+// result at a time. Proxies not yet started when ctx ends are skipped.
+func TestMany(ctx context.Context, items []Proxy, opts PoolOptions, fn func(Proxy, TestResult)) {
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultTestConcurrency
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTestTimeout
+	}
+
+	type result struct {
+		p  Proxy
+		tr TestResult
+	}
+	jobs := make(chan Proxy)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				results <- result{p: p, tr: testOne(ctx, p, opts, timeout)}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, p := range items {
+			select {
+			case jobs <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		fn(r.p, r.tr)
+	}
+}
+
+func testOne(ctx context.Context, p Proxy, opts PoolOptions, timeout time.Duration) TestResult {
+	tp := p
+	if opts.Prepare != nil {
+		var err error
+		if tp, err = opts.Prepare(p); err != nil {
+			return failed(TestResult{}, StageAuth, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return TestConnectivity(ctx, tp, opts.Test)
+}
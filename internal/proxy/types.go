@@ -31,12 +31,25 @@ type Proxy struct {
 	Auth AuthType
 	User string
 	Pass string
+	// PassRef points at Pass in the credential vault once it has been
+	// moved out of the proxy record.
+	PassRef string
+	// Insecure skips certificate verification for https proxies.
+	Insecure bool
+	// Weight is the relative share for weighted rotation (default 1).
+	Weight int
 }
 
 func (p Proxy) Address() string {
 	return fmt.Sprintf("%s:%d", p.Host, p.Port)
 }
 
+// Redacted returns p without its password, for responses and exports.
+func (p Proxy) Redacted() Proxy {
+	p.Pass = ""
+	return p
+}
+
 func NewID() string {
 	b := make([]byte, 8)
 	_, _ = rand.Read(b)
@@ -2,23 +2,273 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"net/http"
 	"time"
 )
 
 type TestResult struct {
 	OK      bool
 	Latency time.Duration
-	Error   string
+	// Handshake covers everything after the TCP dial up to a usable tunnel
+	// (or, without a probe target, up to the end of authentication).
+	Handshake time.Duration
+	TTFB      time.Duration
+	Stage     Stage
+	Code      int
+	Error     string
 }
 
-func TestConnectivity(ctx context.Context, p Proxy) TestResult {
+type TestOptions struct {
+	// Target is an optional host:port to CONNECT to through the proxy. When
+	// empty only the proxy's own handshake is exercised.
+	Target string
+}
+
+func TestConnectivity(ctx context.Context, p Proxy, opts TestOptions) TestResult {
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Address())
+	if err != nil {
+		return failed(TestResult{}, StageDial, err)
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	dialed := time.Now()
+	tunnel, err := negotiate(ctx, conn, p)
+	if err != nil {
+		return failed(TestResult{}, StageHandshake, err)
+	}
+	if opts.Target == "" {
+		if err := probeHandshake(tunnel, p); err != nil {
+			return failed(TestResult{Handshake: time.Since(dialed)}, StageHandshake, err)
+		}
+		return TestResult{OK: true, Handshake: time.Since(dialed), Latency: time.Since(start)}
+	}
+
+	if err := connectRequest(tunnel, p, opts.Target); err != nil {
+		return failed(TestResult{Handshake: time.Since(dialed)}, StageConnect, err)
+	}
+	res := TestResult{Handshake: time.Since(dialed), Latency: time.Since(start)}
+
+	ttfb, err := probeTarget(ctx, tunnel, opts.Target)
+	if err != nil {
+		return failed(res, StageTarget, err)
+	}
+	res.TTFB = ttfb
+	res.OK = true
+	return res
+}
+
+// HopResult is one hop of a chain test. Its Latency and Handshake cover only
+// this hop (the first hop's Latency includes the TCP dial); Cumulative runs
+// from the start of the test until this hop's tunnel was up.
+type HopResult struct {
+	Hop  int
+	Name string
+	TestResult
+	Cumulative time.Duration
+	// Own is what the hop showed about its own proxy, for per-proxy
+	// records, or nil when it showed nothing. Only the first hop keeps
+	// timings: later ones were timed through the hops before them. A hop
+	// whose well-formed reply refused the CONNECT to the next hop still
+	// counts as working; the failure stays in the chain result.
+	Own *TestResult `json:"-"`
+}
+
+type ChainTestResult struct {
+	OK      bool
+	Latency time.Duration
+	// FailedHop is the 1-based hop that failed, or 0. A hop that cannot
+	// reach the next one is the one that failed.
+	FailedHop int
+	Hops      []HopResult
+}
+
+// TestChainHops dials hops one at a time like DialHops, timing each. The last
+// hop CONNECTs to target when one is given and otherwise only has its
+// handshake probed, as in TestConnectivity. Hops after a failed one are left
+// out of the result.
+func TestChainHops(ctx context.Context, hops []Proxy, target string) ChainTestResult {
+	var res ChainTestResult
+	if len(hops) == 0 {
+		return res
+	}
 	start := time.Now()
-	d := net.Dialer{}
-	c, err := d.DialContext(ctx, "tcp", p.Address())
+	own := func(hr HopResult, tr TestResult) *TestResult {
+		if hr.Hop > 1 {
+			tr.Latency, tr.Handshake, tr.TTFB = 0, 0, 0
+		}
+		return &tr
+	}
+	// hopFailed ends the test at hr; counts says whether the failure is the
+	// hop's own rather than that of the hop it tried to reach.
+	hopFailed := func(hr HopResult, stage Stage, err error, counts bool) ChainTestResult {
+		hr.TestResult = failed(hr.TestResult, stage, err)
+		hr.Cumulative = time.Since(start)
+		if counts {
+			hr.Own = own(hr, hr.TestResult)
+		}
+		res.Hops = append(res.Hops, hr)
+		res.FailedHop = hr.Hop
+		res.Latency = hr.Cumulative
+		return res
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hops[0].Address())
 	if err != nil {
-		return TestResult{OK: false, Error: err.Error()}
+		return hopFailed(HopResult{Hop: 1, Name: hops[0].Name}, StageDial, err, true)
+	}
+	defer func() { _ = conn.Close() }()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	for i, hop := range hops {
+		hr := HopResult{Hop: i + 1, Name: hop.Name}
+		handshakeStart := time.Now()
+		hopStart := handshakeStart
+		if i == 0 {
+			hopStart = start
+		}
+		tunnel, err := negotiate(ctx, conn, hop)
+		if err != nil {
+			hr.Handshake = time.Since(handshakeStart)
+			return hopFailed(hr, StageHandshake, err, true)
+		}
+		conn = tunnel
+		// the hop's own part, before it reaches out to anything else
+		negotiated := TestResult{OK: true, Latency: time.Since(hopStart), Handshake: time.Since(handshakeStart)}
+
+		last := i+1 == len(hops)
+		stage := StageConnect
+		switch {
+		case !last:
+			err = connectRequest(conn, hop, hops[i+1].Address())
+		case target != "":
+			err = connectRequest(conn, hop, target)
+		default:
+			stage = StageHandshake
+			err = probeHandshake(conn, hop)
+		}
+		hr.Handshake = time.Since(handshakeStart)
+		if err != nil {
+			if last || errors.Is(err, ErrAuthFailed) {
+				return hopFailed(hr, stage, err, true)
+			}
+			res := hopFailed(hr, stage, err, false)
+			var re *ReplyError
+			if errors.As(err, &re) {
+				res.Hops[i].Own = own(hr, negotiated)
+			}
+			return res
+		}
+		hr.Latency = time.Since(hopStart)
+		if last && target != "" {
+			ttfb, err := probeTarget(ctx, conn, target)
+			if err != nil {
+				return hopFailed(hr, StageTarget, err, true)
+			}
+			hr.TTFB = ttfb
+		}
+		hr.OK = true
+		hr.Cumulative = time.Since(start)
+		if last {
+			hr.Own = own(hr, hr.TestResult)
+		} else {
+			hr.Own = own(hr, negotiated)
+		}
+		res.Hops = append(res.Hops, hr)
+	}
+	res.OK = true
+	res.Latency = time.Since(start)
+	return res
+}
+
+func failed(res TestResult, stage Stage, err error) TestResult {
+	res.OK = false
+	res.Stage = stage
+	res.Error = err.Error()
+	var se *StageError
+	if errors.As(err, &se) {
+		res.Stage = se.Stage
+	}
+	var re *ReplyError
+	if errors.As(err, &re) {
+		res.Code = re.Code
+	}
+	return res
+}
+
+// probeHandshake checks that something speaking p.Type answers on the port
+// when there is no target to CONNECT to. SOCKS5 and TLS have already been
+// exercised by negotiate; HTTP and SOCKS4 have no greeting, so send a request
+// that any such server has to answer. For HTTP any status but 407 shows a
+// working proxy that accepted our credentials.
+func probeHandshake(conn net.Conn, p Proxy) error {
+	switch p.Type {
+	case TypeHTTP, TypeHTTPS:
+		req := "OPTIONS * HTTP/1.1\r\nHost: " + p.Address() + "\r\n" + proxyAuthorization(p) + "\r\n"
+		if _, err := io.WriteString(conn, req); err != nil {
+			return stageErr(StageHandshake, err)
+		}
+		resp, err := readResponse(conn)
+		if err != nil {
+			return stageErr(StageHandshake, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return stageErr(StageAuth, &ReplyError{Type: p.Type, Code: resp.StatusCode, Detail: http.StatusText(resp.StatusCode), Err: ErrAuthFailed})
+		}
+		return nil
+	case TypeSOCKS4:
+		// a CONNECT to 0.0.0.0:0 is refused by every real server, but the
+		// refusal proves it speaks SOCKS4 and tells us whether the user id
+		// is accepted
+		err := socks4Request(conn, p, net.IPv4zero.To4(), "", 0)
+		if errors.Is(err, ErrRejected) {
+			return nil
+		}
+		var se *StageError
+		if errors.As(err, &se) && se.Stage == StageConnect {
+			return stageErr(StageHandshake, se.Err)
+		}
+		return err
+	default:
+		return nil
+	}
+}
+
+// probeTarget measures time to first byte from target over an established
+// tunnel. TLS ports get a handshake, everything else a HEAD request.
+func probeTarget(ctx context.Context, conn net.Conn, target string) (time.Duration, error) {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if port == 443 {
+		// only reachability is measured here, not the target's identity
+		tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	}
+	req := "HEAD / HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		return 0, err
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return 0, err
 	}
-	_ = c.Close()
-	return TestResult{OK: true, Latency: time.Since(start)}
+	return time.Since(start), nil
 }
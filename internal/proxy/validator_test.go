@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serveOnce accepts connections on a local port and hands each to handle.
+func serveOnce(t *testing.T, handle func(net.Conn)) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	a := ln.Addr().(*net.TCPAddr)
+	return a.IP.String(), a.Port
+}
+
+// basicAuthProxy answers every request with 200 when it carries user:pass
+// and with 407 otherwise.
+func basicAuthProxy(user, pass string) func(net.Conn) {
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	return func(c net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != want {
+			_, _ = io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		_, _ = io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	}
+}
+
+func TestConnectivityHTTPHandshake(t *testing.T) {
+	authHost, authPort := serveOnce(t, basicAuthProxy("op", "secret"))
+	junkHost, junkPort := serveOnce(t, func(c net.Conn) {
+		_, _ = io.WriteString(c, "SSH-2.0-OpenSSH_9.6\r\n")
+	})
+
+	tests := []struct {
+		name  string
+		p     Proxy
+		ok    bool
+		stage Stage
+	}{
+		{"credentials accepted", Proxy{Type: TypeHTTP, Host: authHost, Port: authPort, Auth: AuthBasic, User: "op", Pass: "secret"}, true, ""},
+		{"no credentials", Proxy{Type: TypeHTTP, Host: authHost, Port: authPort}, false, StageAuth},
+		{"wrong credentials", Proxy{Type: TypeHTTP, Host: authHost, Port: authPort, Auth: AuthBasic, User: "op", Pass: "guess"}, false, StageAuth},
+		{"not an http proxy", Proxy{Type: TypeHTTP, Host: junkHost, Port: junkPort}, false, StageHandshake},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			res := TestConnectivity(ctx, tt.p, TestOptions{})
+			if res.OK != tt.ok || res.Stage != tt.stage {
+				t.Errorf("got ok=%v stage=%q (%s), want ok=%v stage=%q", res.OK, res.Stage, res.Error, tt.ok, tt.stage)
+			}
+		})
+	}
+}
+
+// connectProxy is an HTTP proxy that CONNECTs to whatever it is asked and
+// answers 502 when that fails.
+func connectProxy(c net.Conn) {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	up, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer up.Close()
+	_, _ = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() { _, _ = io.Copy(up, br) }()
+	_, _ = io.Copy(c, up)
+}
+
+func TestChainHopsOwnResults(t *testing.T) {
+	firstHost, firstPort := serveOnce(t, connectProxy)
+	first := Proxy{Name: "first", Type: TypeHTTP, Host: firstHost, Port: firstPort}
+	authHost, authPort := serveOnce(t, basicAuthProxy("op", "secret"))
+	locked := Proxy{Name: "locked", Type: TypeHTTP, Host: authHost, Port: authPort}
+	open := locked
+	open.Name, open.Auth, open.User, open.Pass = "open", AuthBasic, "op", "secret"
+
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().(*net.TCPAddr)
+	_ = dead.Close()
+	gone := Proxy{Name: "gone", Type: TypeHTTP, Host: deadAddr.IP.String(), Port: deadAddr.Port}
+
+	run := func(hops ...Proxy) ChainTestResult {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return TestChainHops(ctx, hops, "")
+	}
+
+	res := run(first, open)
+	if !res.OK || len(res.Hops) != 2 {
+		t.Fatalf("chain = %+v", res)
+	}
+	if own := res.Hops[0].Own; own == nil || !own.OK || own.Latency == 0 {
+		t.Errorf("first hop own = %+v", own)
+	}
+	if own := res.Hops[1].Own; own == nil || !own.OK || own.Latency != 0 || own.Handshake != 0 {
+		t.Errorf("second hop own = %+v, want a success without timings", own)
+	}
+
+	// the second hop's auth failure is its own
+	res = run(first, locked)
+	if res.FailedHop != 2 {
+		t.Fatalf("failed hop = %d", res.FailedHop)
+	}
+	if own := res.Hops[1].Own; own == nil || own.OK || own.Stage != StageAuth || own.Latency != 0 {
+		t.Errorf("second hop own = %+v", own)
+	}
+
+	// a dead next hop is not the first hop's failure
+	res = run(first, gone)
+	if res.FailedHop != 1 || res.Hops[0].OK {
+		t.Fatalf("chain = %+v", res)
+	}
+	if own := res.Hops[0].Own; own == nil || !own.OK {
+		t.Errorf("first hop own = %+v, want a success", own)
+	}
+}
@@ -0,0 +1,144 @@
+package rootproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/lily0ng/RootProxy/internal/cert"
+	"github.com/lily0ng/RootProxy/internal/state"
+	"github.com/lily0ng/RootProxy/internal/vault"
+)
+
+// APICertName is the certificate the API serves unless another is named.
+const APICertName = "api"
+
+// APIKeyFile holds the private key of the generated API certificate, next
+// to the state file. It is kept out of the vault so the API can start before
+// the vault is unlocked.
+const APIKeyFile = "api-key.pem"
+
+// APITLSConfig builds the TLS config of the API server. Without a name the
+// default certificate is served, generated self-signed for addr on first
+// use with its key in APIKeyFile. Any other certificate, or one named
+// APICertName explicitly that has a KeyRef, is served with the vault key its
+// KeyRef names, so the vault must be unlocked. A non-empty
+// clientCA names the certificate that client certificates must be signed
+// by.
+func (a *App) APITLSConfig(name, clientCA, addr string) (*tls.Config, error) {
+	var pair tls.Certificate
+	var err error
+	if c, ok := a.Certs.Get(APICertName); name == APICertName && ok && c.KeyRef != "" {
+		pair, err = a.vaultAPICert(name)
+	} else if name == "" || name == APICertName {
+		pair, err = a.defaultAPICert(addr)
+	} else {
+		pair, err = a.vaultAPICert(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return cfg, nil
+	}
+	ca, ok := a.Certs.Get(clientCA)
+	if !ok {
+		return nil, fmt.Errorf("client CA %s: certificate not found", clientCA)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca.PEM) {
+		return nil, errors.New("client CA " + clientCA + ": invalid PEM")
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+// apiHosts lists the names a generated API certificate is valid for: the
+// listen host, or this machine's host name when listening everywhere, and
+// the loopback names.
+func apiHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, _ := net.SplitHostPort(addr)
+	switch host {
+	case "", "0.0.0.0", "::":
+		if hn, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hn)
+		}
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// defaultAPICert loads the generated API certificate, or generates it when
+// no certificate is named APICertName yet. An existing one whose key is not
+// in APIKeyFile is never replaced, since it may be managed by the user.
+func (a *App) defaultAPICert(addr string) (tls.Certificate, error) {
+	keyPath := ""
+	if a.stateDir != "" {
+		keyPath = filepath.Join(a.stateDir, APIKeyFile)
+	}
+	if c, ok := a.Certs.Get(APICertName); ok {
+		var keyPEM []byte
+		err := os.ErrNotExist
+		if keyPath != "" {
+			keyPEM, err = os.ReadFile(keyPath)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return tls.Certificate{}, fmt.Errorf("certificate %s: %s is missing; serve it with --api-cert %s after storing its key in the vault, name another certificate with --api-cert, or delete it to generate a new one", APICertName, APIKeyFile, APICertName)
+		}
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("certificate %s: read key: %w", APICertName, err)
+		}
+		pair, err := tls.X509KeyPair(c.PEM, keyPEM)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("certificate %s: %w", APICertName, err)
+		}
+		return pair, nil
+	}
+
+	gen, err := cert.GenerateSelfSigned(cert.SelfSignedOptions{CommonName: "RootProxy API", Hosts: apiHosts(addr)})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if keyPath != "" {
+		if err := state.WriteFileAtomic(keyPath, gen.KeyPEM, 0o600); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	if err := a.Certs.Add(APICertName, gen.CertPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(gen.CertPEM, gen.KeyPEM)
+}
+
+// vaultAPICert loads a stored certificate with the vault key it refers to.
+func (a *App) vaultAPICert(name string) (tls.Certificate, error) {
+	c, ok := a.Certs.Get(name)
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("certificate %s: not found", name)
+	}
+	if c.KeyRef == "" {
+		return tls.Certificate{}, fmt.Errorf("certificate %s: no private key stored in the vault", name)
+	}
+	keyPEM, err := a.Vault.Get(c.KeyRef)
+	if errors.Is(err, vault.ErrLocked) {
+		return tls.Certificate{}, fmt.Errorf("certificate %s: the vault is locked; start with --vault-passphrase-file to use its private key", name)
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("certificate %s: %w", name, err)
+	}
+	pair, err := tls.X509KeyPair(c.PEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("certificate %s: %w", name, err)
+	}
+	return pair, nil
+}
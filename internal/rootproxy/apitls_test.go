@@ -0,0 +1,204 @@
+package rootproxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/cert"
+)
+
+func TestAPITLSConfigGeneratesAndReloads(t *testing.T) {
+	dir := t.TempDir()
+	app, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.APITLSConfig("", "", "127.0.0.1:8443"); err != nil {
+		t.Fatal(err)
+	}
+	first, ok := app.Certs.Get(APICertName)
+	if !ok {
+		t.Fatal("api certificate not stored")
+	}
+	leaf, err := cert.ParsePEM(first.PEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("generated certificate: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.APITLSConfig("", "", "127.0.0.1:8443"); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := reopened.Certs.Get(APICertName)
+	if string(second.PEM) != string(first.PEM) {
+		t.Error("certificate regenerated on reload")
+	}
+}
+
+func TestAPITLSConfigVaultKey(t *testing.T) {
+	app := NewApp()
+	gen, err := cert.GenerateSelfSigned(cert.SelfSignedOptions{CommonName: "lab", Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Certs.Add("lab", gen.CertPEM); err != nil {
+		t.Fatal(err)
+	}
+	_ = app.Certs.SetKeyRef("lab", "cert/lab")
+
+	if _, err := app.APITLSConfig("lab", "", ""); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("locked vault: got %v", err)
+	}
+	if err := app.UnlockVault("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Vault.Put("cert/lab", gen.KeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := app.APITLSConfig("lab", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if leaf.Subject.CommonName != "lab" {
+		t.Errorf("served %q, want lab", leaf.Subject.CommonName)
+	}
+	if _, err := app.APITLSConfig("missing", "", ""); err == nil {
+		t.Error("missing certificate accepted")
+	}
+}
+
+func TestAPITLSConfigKeepsUserAPICert(t *testing.T) {
+	app, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := cert.GenerateSelfSigned(cert.SelfSignedOptions{CommonName: "mine", Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Certs.Add(APICertName, gen.CertPEM); err != nil {
+		t.Fatal(err)
+	}
+	_ = app.Certs.SetKeyRef(APICertName, "cert/api")
+
+	if _, err := app.APITLSConfig("", "", "127.0.0.1:8443"); err == nil || !strings.Contains(err.Error(), "--api-cert") {
+		t.Fatalf("missing key file: got %v", err)
+	}
+	kept, _ := app.Certs.Get(APICertName)
+	if string(kept.PEM) != string(gen.CertPEM) || kept.KeyRef != "cert/api" {
+		t.Fatal("user certificate replaced")
+	}
+
+	if err := app.UnlockVault("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Vault.Put("cert/api", gen.KeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := app.APITLSConfig(APICertName, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if leaf.Subject.CommonName != "mine" {
+		t.Errorf("served %q, want mine", leaf.Subject.CommonName)
+	}
+}
+
+func TestAPITLSConfigClientCA(t *testing.T) {
+	app := NewApp()
+	caKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "team-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Certs.Add("team-ca", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})); err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	clientKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := app.APITLSConfig("", "team-ca", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.(*tls.Conn).Handshake()
+			_ = c.Close()
+		}
+	}()
+
+	served, _ := app.Certs.Get(APICertName)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(served.PEM)
+	handshake := func(certs []tls.Certificate) error {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// TLS 1.3 reports a rejected client certificate on the first read
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = c.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		if err != nil && strings.Contains(err.Error(), "EOF") {
+			return nil
+		}
+		return err
+	}
+
+	if err := handshake(nil); err == nil {
+		t.Error("client without certificate accepted")
+	}
+	client := tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+	if err := handshake([]tls.Certificate{client}); err != nil {
+		t.Errorf("client certificate rejected: %v", err)
+	}
+}
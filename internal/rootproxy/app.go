@@ -1,66 +1,291 @@
 package rootproxy
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/lily0ng/RootProxy/internal/auth"
 	"github.com/lily0ng/RootProxy/internal/cert"
 	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/events"
+	"github.com/lily0ng/RootProxy/internal/forwarder"
 	"github.com/lily0ng/RootProxy/internal/monitor"
 	"github.com/lily0ng/RootProxy/internal/proxy"
+	"github.com/lily0ng/RootProxy/internal/state"
+	"github.com/lily0ng/RootProxy/internal/vault"
 )
 
+const retestTimeout = 3 * time.Second
+
 type App struct {
-	Proxies  *proxy.Manager
-	Chains   *proxy.ChainStore
-	Rotator  *proxy.Rotator
-	Monitor  *monitor.Store
-	Certs    *cert.Manager
-	Profiles *config.ProfileStore
-	Routing  *config.RoutingStore
-	Security *config.SecurityStore
-	Settings *config.Settings
+	Proxies   *proxy.Manager
+	Chains    *proxy.ChainStore
+	Dialer    *proxy.Dialer
+	Rotator   *proxy.Rotator
+	Scheduler *proxy.Scheduler
+	Monitor   *monitor.Store
+	Certs     *cert.Manager
+	Profiles  *config.ProfileStore
+	Routing   *config.RoutingStore
+	Security  *config.SecurityStore
+	// Settings may be changed directly only at startup, for command line
+	// overrides; later changes go through UpdateSettings.
+	Settings  *config.Settings
+	Listeners *forwarder.Manager
+	Vault     *vault.Vault
+	Health    *HealthChecker
+	Events    *events.Bus
+	Tokens    *auth.Store
+
+	saveMu   sync.Mutex
+	stateDir string
+	// settingsMu guards Settings and savedSettings. savedSettings is what
+	// gets persisted; Settings may carry one-off overrides from command
+	// line flags.
+	settingsMu    sync.RWMutex
+	savedSettings config.Settings
+
+	eventsMu    sync.Mutex
+	lastActive  string
+	lastProfile string
 }
 
 func NewApp() *App {
-	settings := config.DefaultSettings()
+	app := newApp(config.DefaultSettings())
+	app.seed()
+	return app
+}
+
+// Open loads the state saved in dir, or seeds a fresh one, and saves every
+// later mutation of the stores back to dir.
+func Open(dir string) (*App, error) {
+	f, ok, err := state.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := vault.Open(filepath.Join(dir, vault.FileName))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := auth.Open(filepath.Join(dir, auth.FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var app *App
+	if ok {
+		settings := f.Settings
+		app = newApp(&settings)
+		if err := app.restore(f); err != nil {
+			return nil, err
+		}
+	} else {
+		app = NewApp()
+	}
+	app.Vault = v
+	app.Tokens = tokens
+	app.stateDir = dir
+	app.savedSettings = *app.Settings
+	app.watch()
+	app.Scheduler.Sync(app.Profiles.List())
+	if err := app.Health.Configure(app.Settings.HealthCheck); err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := app.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return app, nil
+}
+
+func newApp(settings *config.Settings) *App {
 	proxies := proxy.NewManager()
-	chains := proxy.NewChainStore()
 	rotator := proxy.NewRotator()
-	mon := monitor.NewStore()
-	certs := cert.NewManager()
 	profiles := config.NewProfileStore(settings.DefaultProfile)
-	routing := config.NewRoutingStore()
-	security := config.NewSecurityStore()
+	app := &App{
+		Proxies:   proxies,
+		Chains:    proxy.NewChainStore(),
+		Dialer:    proxy.NewDialer(proxies),
+		Rotator:   rotator,
+		Scheduler: proxy.NewScheduler(rotator, proxies, profiles.Active),
+		Monitor:   monitor.NewStore(),
+		Certs:     cert.NewManager(),
+		Profiles:  profiles,
+		Routing:   config.NewRoutingStore(),
+		Security:  config.NewSecurityStore(),
+		Settings:  settings,
+	}
+	app.Vault, _ = vault.Open("")
+	app.Tokens, _ = auth.Open("")
+	app.Listeners = forwarder.NewManager(app)
+	app.Health = newHealthChecker(app)
+	app.Events = events.NewBus(events.DefaultSize)
+	rotator.SetHealth(app.Monitor, app.retest)
+	app.Scheduler.SetOnRotate(func(ev proxy.RotationEvent) {
+		app.Monitor.RecordRotation(ev.Profile, ev.Manual)
+		app.publishRotation(ev)
+	})
+	proxies.SetOnChange(app.proxiesChanged)
+	profiles.SetOnChange(app.profilesChanged)
+	app.Routing.SetOnChange(app.routingChanged)
+	app.publishEvents()
+	return app
+}
+
+// retest runs a connectivity test for health-checked rotation and records
+// it like any other test.
+func (a *App) retest(p proxy.Proxy) proxy.TestResult {
+	p, err := a.ResolveSecrets(p)
+	if err != nil {
+		return proxy.TestResult{Stage: proxy.StageAuth, Error: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), retestTimeout)
+	defer cancel()
+	tr := proxy.TestConnectivity(ctx, p, proxy.TestOptions{Target: a.CurrentSettings().ProbeTarget})
+	a.Monitor.RecordTest(p.Name, tr)
+	return tr
+}
 
-	_ = proxies.Add(proxy.Proxy{
+// TestChain tests the named chain hop by hop and records in the monitor
+// store what each hop showed about its own proxy.
+func (a *App) TestChain(ctx context.Context, name, target string) (proxy.ChainTestResult, error) {
+	c, ok := a.Chains.Get(name)
+	if !ok {
+		return proxy.ChainTestResult{}, fmt.Errorf("chain not found: %s", name)
+	}
+	hops, err := a.ResolveChain(c)
+	if err != nil {
+		return proxy.ChainTestResult{}, err
+	}
+	res := proxy.TestChainHops(ctx, hops, target)
+	for _, h := range res.Hops {
+		if h.Own != nil {
+			a.Monitor.RecordTest(h.Name, *h.Own)
+		}
+	}
+	return res, nil
+}
+
+func (a *App) seed() {
+	_ = a.Proxies.Add(proxy.Proxy{
 		Name: "HTB-Lab-TOR",
 		Type: proxy.TypeSOCKS5,
 		Host: "127.0.0.1",
 		Port: 9050,
 	})
-	_ = proxies.Add(proxy.Proxy{
+	_ = a.Proxies.Add(proxy.Proxy{
 		Name: "Burp-Suite",
 		Type: proxy.TypeHTTP,
 		Host: "127.0.0.1",
 		Port: 8080,
 	})
 
-	_ = profiles.Upsert(config.Profile{
+	_ = a.Profiles.Upsert(config.Profile{
 		Name:      "htb-pentest",
 		Chain:     []string{"HTB-Lab-TOR", "Burp-Suite"},
 		UpdatedAt: time.Now().UTC(),
 	})
-	_ = profiles.SetActive(settings.DefaultProfile)
-
-	return &App{
-		Proxies:  proxies,
-		Chains:   chains,
-		Rotator:  rotator,
-		Monitor:  mon,
-		Certs:    certs,
-		Profiles: profiles,
-		Routing:  routing,
-		Security: security,
-		Settings: settings,
+	_ = a.Profiles.SetActive(a.Settings.DefaultProfile)
+}
+
+func (a *App) restore(f state.File) error {
+	for _, p := range f.Proxies {
+		if err := a.Proxies.Add(p); err != nil {
+			return fmt.Errorf("restore proxy %s: %w", p.Name, err)
+		}
+	}
+	if f.ActiveProxy != "" {
+		_ = a.Proxies.SetActive(f.ActiveProxy)
 	}
+	for _, c := range f.Chains {
+		if err := a.Chains.Upsert(c, proxy.MaxChainHops); err != nil {
+			return fmt.Errorf("restore chain %s: %w", c.Name, err)
+		}
+	}
+	for _, p := range f.Profiles {
+		if err := a.Profiles.Upsert(p); err != nil {
+			return fmt.Errorf("restore profile %s: %w", p.Name, err)
+		}
+	}
+	if f.ActiveProfile != "" {
+		_ = a.Profiles.SetActive(f.ActiveProfile)
+	}
+	for _, r := range f.Routing {
+		if err := a.Routing.Upsert(r); err != nil {
+			return fmt.Errorf("restore routing rule %s: %w", r.Name, err)
+		}
+	}
+	a.Security.Set(f.Security)
+	for _, c := range f.Certs {
+		if err := a.Certs.Add(c.Name, c.PEM); err != nil {
+			return fmt.Errorf("restore certificate %s: %w", c.Name, err)
+		}
+		if c.KeyRef != "" {
+			_ = a.Certs.SetKeyRef(c.Name, c.KeyRef)
+		}
+	}
+	return nil
+}
+
+func (a *App) watch() {
+	persist := func() {
+		if err := a.Save(); err != nil {
+			logrus.WithError(err).Error("failed to save state")
+		}
+	}
+	a.Proxies.SetOnChange(func() {
+		persist()
+		a.proxiesChanged()
+	})
+	a.Chains.SetOnChange(persist)
+	a.Profiles.SetOnChange(func() {
+		persist()
+		a.Scheduler.Sync(a.Profiles.List())
+		a.profilesChanged()
+	})
+	a.Routing.SetOnChange(func() {
+		persist()
+		a.routingChanged()
+	})
+	a.Security.SetOnChange(persist)
+	a.Certs.SetOnChange(persist)
+}
+
+// Close stops background rotation, health checks and every running
+// listener.
+func (a *App) Close() {
+	a.Scheduler.Stop()
+	a.Health.Stop()
+	a.Listeners.StopAll()
+}
+
+// Save writes the current state to the state directory. It is a no-op for
+// in-memory apps created with NewApp.
+func (a *App) Save() error {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	if a.stateDir == "" {
+		return nil
+	}
+	a.settingsMu.RLock()
+	settings := a.savedSettings
+	a.settingsMu.RUnlock()
+	return state.Save(a.stateDir, state.File{
+		Settings:      settings,
+		Proxies:       a.Proxies.List(),
+		ActiveProxy:   a.Proxies.ActiveName(),
+		Chains:        a.Chains.List(),
+		Profiles:      a.Profiles.List(),
+		ActiveProfile: a.Profiles.Active(),
+		Routing:       a.Routing.List(),
+		Security:      a.Security.Get(),
+		Certs:         a.Certs.List(),
+	})
 }
@@ -0,0 +1,191 @@
+package rootproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/proxy"
+)
+
+type Route struct {
+	Decision config.Decision
+	Direct   bool
+	Hops     []proxy.Proxy
+	// Pick is the rotation mode when PreviewRoute cannot name the proxy
+	// because each connection draws one at random; Hops is empty then.
+	Pick config.RotationMode
+}
+
+func (a *App) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := a.Route(host, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rt.Direct {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	hops, err := a.resolveHops(rt.Hops)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.DialHops(ctx, hops, network, addr)
+}
+
+// Route evaluates the routing rules for a destination and resolves the
+// matching action to concrete hops. Destinations no rule matches use the
+// listener upstream from settings.
+func (a *App) Route(host string, ip net.IP) (Route, error) {
+	return a.route(host, ip, false)
+}
+
+// PreviewRoute resolves a destination like Route without side effects:
+// sticky assignments and rotation positions are left as they are.
+func (a *App) PreviewRoute(host string, ip net.IP) (Route, error) {
+	return a.route(host, ip, true)
+}
+
+func (a *App) route(host string, ip net.IP, preview bool) (Route, error) {
+	d, err := a.Routing.Resolve(host, ip)
+	if err != nil {
+		return Route{}, err
+	}
+	rt := Route{Decision: d}
+	if !d.Matched {
+		rt.Hops, err = a.defaultHops(host, ip, preview)
+		if errors.Is(err, proxy.ErrRandomPick) {
+			prof, _ := a.activeProfile()
+			rt.Pick = prof.Rotation.Mode
+			return rt, nil
+		}
+		return rt, err
+	}
+
+	switch d.Action {
+	case config.RouteDirect:
+		rt.Direct = true
+	case config.RouteProxy:
+		var p proxy.Proxy
+		if d.Target == "" {
+			p, err = a.activeProxy()
+		} else {
+			p, err = a.proxyByName(d.Target)
+		}
+		rt.Hops = []proxy.Proxy{p}
+	case config.RouteChain:
+		rt.Hops, err = a.chainHops(d.Target)
+	case config.RouteProfile:
+		name := d.Target
+		if name == "" {
+			name = a.Profiles.Active()
+		}
+		rt.Hops, err = a.profileHops(name)
+	default:
+		err = fmt.Errorf("unsupported routing action: %s", d.Action)
+	}
+	if err != nil {
+		return Route{}, err
+	}
+	return rt, nil
+}
+
+func (a *App) defaultHops(host string, ip net.IP, preview bool) ([]proxy.Proxy, error) {
+	ls := a.CurrentSettings().Listeners
+	switch ls.Upstream {
+	case config.UpstreamProfileChain:
+		return a.profileHops(a.Profiles.Active())
+	case config.UpstreamChain:
+		return a.chainHops(ls.UpstreamChain)
+	case config.UpstreamSticky:
+		if host == "" && ip != nil {
+			host = ip.String()
+		}
+		p, err := a.stickyProxy(host, preview)
+		if err != nil {
+			return nil, err
+		}
+		return []proxy.Proxy{p}, nil
+	case config.UpstreamRotate:
+		p, err := a.rotatedProxy(preview)
+		if err != nil {
+			return nil, err
+		}
+		return []proxy.Proxy{p}, nil
+	default:
+		p, err := a.activeProxy()
+		if err != nil {
+			return nil, err
+		}
+		return []proxy.Proxy{p}, nil
+	}
+}
+
+func (a *App) activeProxy() (proxy.Proxy, error) {
+	p, ok := a.Proxies.GetActive()
+	if !ok {
+		return proxy.Proxy{}, errors.New("no active proxy")
+	}
+	return p, nil
+}
+
+func (a *App) proxyByName(name string) (proxy.Proxy, error) {
+	p, ok := a.Proxies.GetByName(name)
+	if !ok {
+		return proxy.Proxy{}, fmt.Errorf("proxy not found: %s", name)
+	}
+	return p, nil
+}
+
+func (a *App) stickyProxy(host string, preview bool) (proxy.Proxy, error) {
+	prof, err := a.activeProfile()
+	if err != nil {
+		return proxy.Proxy{}, err
+	}
+	if preview {
+		return a.Rotator.PeekSticky(prof.Name, host, prof.Chain, prof.Rotation, a.Proxies)
+	}
+	return a.Rotator.Sticky(prof.Name, host, prof.Chain, prof.Rotation, a.Proxies)
+}
+
+func (a *App) rotatedProxy(preview bool) (proxy.Proxy, error) {
+	prof, err := a.activeProfile()
+	if err != nil {
+		return proxy.Proxy{}, err
+	}
+	if preview {
+		return a.Rotator.Peek(prof.Name, prof.Chain, prof.Rotation, a.Proxies)
+	}
+	return a.Rotator.Select(prof.Name, prof.Chain, prof.Rotation, a.Proxies)
+}
+
+func (a *App) activeProfile() (config.Profile, error) {
+	name := a.Profiles.Active()
+	prof, ok := a.Profiles.Get(name)
+	if !ok {
+		return config.Profile{}, fmt.Errorf("profile not found: %s", name)
+	}
+	return prof, nil
+}
+
+func (a *App) chainHops(name string) ([]proxy.Proxy, error) {
+	c, ok := a.Chains.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("chain not found: %s", name)
+	}
+	return a.Dialer.Resolve(c)
+}
+
+func (a *App) profileHops(name string) ([]proxy.Proxy, error) {
+	p, ok := a.Profiles.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("profile not found: %s", name)
+	}
+	return a.Dialer.Resolve(proxy.Chain{Name: p.Name, Hops: p.Chain})
+}
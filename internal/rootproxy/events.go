@@ -0,0 +1,97 @@
+package rootproxy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/events"
+	"github.com/lily0ng/RootProxy/internal/forwarder"
+	"github.com/lily0ng/RootProxy/internal/proxy"
+)
+
+// publishEvents hooks proxy tests and listener connections up to the event
+// bus. Store changes are published by proxiesChanged, profilesChanged and
+// routingChanged, rotations by publishRotation.
+func (a *App) publishEvents() {
+	a.Monitor.SetOnTest(func(name string, tr proxy.TestResult) {
+		fields := map[string]string{"proxy": name, "latency": tr.Latency.String()}
+		if tr.OK {
+			a.Events.Publish(events.LevelInfo, events.TopicTest,
+				fmt.Sprintf("%s test ok (%s)", name, tr.Latency.Round(time.Millisecond)), fields)
+			return
+		}
+		fields["stage"] = string(tr.Stage)
+		fields["error"] = tr.Error
+		a.Events.Publish(events.LevelWarn, events.TopicTest,
+			fmt.Sprintf("%s test failed at %s: %s", name, tr.Stage, tr.Error), fields)
+	})
+	a.Listeners.SetOnConn(func(ev forwarder.ConnEvent) {
+		fields := map[string]string{"kind": string(ev.Kind), "remote": ev.Remote}
+		if !ev.Closed {
+			a.Events.Publish(events.LevelDebug, events.TopicListener,
+				fmt.Sprintf("%s connection from %s", ev.Kind, ev.Remote), fields)
+			return
+		}
+		fields["bytes_in"] = strconv.FormatUint(ev.BytesIn, 10)
+		fields["bytes_out"] = strconv.FormatUint(ev.BytesOut, 10)
+		fields["duration"] = ev.Duration.String()
+		a.Events.Publish(events.LevelDebug, events.TopicListener,
+			fmt.Sprintf("%s connection from %s closed after %s (%d bytes in, %d out)",
+				ev.Kind, ev.Remote, ev.Duration.Round(time.Millisecond), ev.BytesIn, ev.BytesOut), fields)
+	})
+}
+
+func (a *App) publishRotation(ev proxy.RotationEvent) {
+	trigger := "scheduled"
+	if ev.Manual {
+		trigger = "manual"
+	}
+	a.Events.Publish(events.LevelInfo, events.TopicRotation,
+		fmt.Sprintf("%s rotation in %s: %s -> %s", trigger, ev.Profile, ev.From, ev.To),
+		map[string]string{"profile": ev.Profile, "from": ev.From, "to": ev.To, "trigger": trigger})
+}
+
+// proxiesChanged publishes a switch of the active proxy.
+func (a *App) proxiesChanged() {
+	active := a.Proxies.ActiveName()
+	a.eventsMu.Lock()
+	prev := a.lastActive
+	a.lastActive = active
+	a.eventsMu.Unlock()
+	if active == prev {
+		return
+	}
+	a.Events.Publish(events.LevelInfo, events.TopicProxy,
+		fmt.Sprintf("active proxy: %s -> %s", orDash(prev), orDash(active)),
+		map[string]string{"from": prev, "to": active})
+}
+
+// profilesChanged publishes a switch of the active profile.
+func (a *App) profilesChanged() {
+	active := a.Profiles.Active()
+	a.eventsMu.Lock()
+	prev := a.lastProfile
+	a.lastProfile = active
+	a.eventsMu.Unlock()
+	if active == prev {
+		return
+	}
+	a.Events.Publish(events.LevelInfo, events.TopicProfile,
+		fmt.Sprintf("active profile: %s -> %s", orDash(prev), orDash(active)),
+		map[string]string{"from": prev, "to": active})
+}
+
+func (a *App) routingChanged() {
+	n := len(a.Routing.List())
+	a.Events.Publish(events.LevelInfo, events.TopicRouting,
+		fmt.Sprintf("routing rules changed (%d rules)", n),
+		map[string]string{"rules": strconv.Itoa(n)})
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,194 @@
+package rootproxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/monitor"
+	"github.com/lily0ng/RootProxy/internal/proxy"
+)
+
+type HealthRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Tested     int       `json:"tested"`
+	Passed     int       `json:"passed"`
+	Failed     int       `json:"failed"`
+	Up         int       `json:"up"`
+	Down       int       `json:"down"`
+}
+
+type HealthStatus struct {
+	Interval    string     `json:"interval"`
+	Concurrency int        `json:"concurrency"`
+	Timeout     string     `json:"timeout"`
+	Rise        int        `json:"rise"`
+	Fall        int        `json:"fall"`
+	Enabled     bool       `json:"enabled"`
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"next_run"`
+	LastRun     *HealthRun `json:"last_run"`
+}
+
+// HealthChecker tests every proxy on an interval and records the results
+// in the monitor store. Runs never overlap.
+type HealthChecker struct {
+	app *App
+
+	mu      sync.Mutex
+	cfg     config.HealthCheckSettings
+	stop    chan struct{}
+	running bool
+	nextRun time.Time
+	lastRun *HealthRun
+}
+
+func newHealthChecker(app *App) *HealthChecker {
+	return &HealthChecker{app: app, cfg: app.Settings.HealthCheck}
+}
+
+// Configure applies cfg and restarts the schedule.
+func (h *HealthChecker) Configure(cfg config.HealthCheckSettings) error {
+	if cfg.Interval < 0 || cfg.Timeout < 0 || cfg.Concurrency < 0 || cfg.Rise < 0 || cfg.Fall < 0 {
+		return errors.New("health check settings must not be negative")
+	}
+	if cfg.Interval > 0 && cfg.Interval < time.Second {
+		return errors.New("health check interval must be at least 1s")
+	}
+	h.app.Monitor.SetHysteresis(cfg.Rise, cfg.Fall)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg = cfg
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	h.nextRun = time.Time{}
+	if cfg.Interval > 0 {
+		h.stop = make(chan struct{})
+		h.nextRun = time.Now().Add(cfg.Interval)
+		go h.loop(cfg.Interval, h.stop)
+	}
+	return nil
+}
+
+func (h *HealthChecker) loop(interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			h.mu.Lock()
+			h.nextRun = now.Add(interval)
+			h.mu.Unlock()
+			_, _ = h.Run(context.Background())
+		}
+	}
+}
+
+var ErrHealthCheckRunning = errors.New("health check already running")
+
+// Run tests every proxy once now.
+func (h *HealthChecker) Run(ctx context.Context) (HealthRun, error) {
+	h.mu.Lock()
+	if h.running {
+		h.mu.Unlock()
+		return HealthRun{}, ErrHealthCheckRunning
+	}
+	h.running = true
+	cfg := h.cfg
+	h.mu.Unlock()
+
+	run := HealthRun{StartedAt: time.Now().UTC()}
+	items := h.app.Proxies.List()
+	opts := proxy.PoolOptions{
+		Concurrency: cfg.Concurrency,
+		Timeout:     cfg.Timeout,
+		Test:        proxy.TestOptions{Target: h.app.CurrentSettings().ProbeTarget},
+		Prepare:     h.app.ResolveSecrets,
+	}
+	proxy.TestMany(ctx, items, opts, func(p proxy.Proxy, tr proxy.TestResult) {
+		h.app.Monitor.RecordTest(p.Name, tr)
+		run.Tested++
+		if tr.OK {
+			run.Passed++
+		} else {
+			run.Failed++
+		}
+	})
+	tested := make(map[string]bool, len(items))
+	for _, p := range items {
+		tested[p.Name] = true
+	}
+	for _, m := range h.app.Monitor.Snapshot() {
+		if !tested[m.Name] {
+			continue
+		}
+		switch m.State {
+		case monitor.StateUp:
+			run.Up++
+		case monitor.StateDown:
+			run.Down++
+		}
+	}
+	run.FinishedAt = time.Now().UTC()
+
+	h.mu.Lock()
+	h.running = false
+	h.lastRun = &run
+	h.mu.Unlock()
+	return run, nil
+}
+
+func (h *HealthChecker) Config() config.HealthCheckSettings {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cfg
+}
+
+func (h *HealthChecker) Status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := HealthStatus{
+		Interval:    h.cfg.Interval.String(),
+		Concurrency: h.cfg.Concurrency,
+		Timeout:     h.cfg.Timeout.String(),
+		Rise:        h.cfg.Rise,
+		Fall:        h.cfg.Fall,
+		Enabled:     h.cfg.Interval > 0,
+		Running:     h.running,
+	}
+	if !h.nextRun.IsZero() {
+		next := h.nextRun
+		st.NextRun = &next
+	}
+	if h.lastRun != nil {
+		last := *h.lastRun
+		st.LastRun = &last
+	}
+	return st
+}
+
+func (h *HealthChecker) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	h.nextRun = time.Time{}
+}
+
+// SetHealthCheck reconfigures the health checker and saves the settings.
+func (a *App) SetHealthCheck(cfg config.HealthCheckSettings) error {
+	if err := a.Health.Configure(cfg); err != nil {
+		return err
+	}
+	return a.UpdateSettings(func(s *config.Settings) { s.HealthCheck = cfg })
+}
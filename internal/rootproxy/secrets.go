@@ -0,0 +1,124 @@
+package rootproxy
+
+import (
+	"fmt"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/proxy"
+)
+
+// SealProxy moves p.Pass into the vault and leaves a reference behind. Until
+// a vault passphrase has been set, passwords stay on the proxy record.
+func (a *App) SealProxy(p proxy.Proxy) (proxy.Proxy, error) {
+	if p.Pass == "" || !a.Vault.Initialized() {
+		return p, nil
+	}
+	if p.PassRef == "" {
+		p.PassRef = "proxy/" + proxy.NewID()
+	}
+	if err := a.Vault.Put(p.PassRef, []byte(p.Pass)); err != nil {
+		return proxy.Proxy{}, err
+	}
+	p.Pass = ""
+	return p, nil
+}
+
+// AddProxy seals p's password and adds p. A rejected proxy leaves no
+// password behind in the vault.
+func (a *App) AddProxy(p proxy.Proxy) error {
+	sealed, err := a.SealProxy(p)
+	if err != nil {
+		return err
+	}
+	if err := a.Proxies.Add(sealed); err != nil {
+		if sealed.PassRef != p.PassRef {
+			_ = a.Vault.Delete(sealed.PassRef)
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateProxy seals p's password and replaces the proxy with the given ID,
+// dropping a stored password that p no longer refers to.
+func (a *App) UpdateProxy(id string, p proxy.Proxy) error {
+	old, _ := a.Proxies.GetByID(id)
+	sealed, err := a.SealProxy(p)
+	if err != nil {
+		return err
+	}
+	if err := a.Proxies.Update(id, sealed); err != nil {
+		if sealed.PassRef != p.PassRef {
+			_ = a.Vault.Delete(sealed.PassRef)
+		}
+		return err
+	}
+	if old.PassRef != "" && old.PassRef != sealed.PassRef {
+		// a locked vault keeps the orphaned secret; it is harmless
+		_ = a.Vault.Delete(old.PassRef)
+	}
+	return nil
+}
+
+// ResolveSecrets fills in p.Pass from the vault before it is dialed.
+func (a *App) ResolveSecrets(p proxy.Proxy) (proxy.Proxy, error) {
+	if p.PassRef == "" || p.Pass != "" {
+		return p, nil
+	}
+	b, err := a.Vault.Get(p.PassRef)
+	if err != nil {
+		return proxy.Proxy{}, fmt.Errorf("proxy %s: %w", p.Name, err)
+	}
+	p.Pass = string(b)
+	return p, nil
+}
+
+func (a *App) resolveHops(hops []proxy.Proxy) ([]proxy.Proxy, error) {
+	out := make([]proxy.Proxy, 0, len(hops))
+	for _, h := range hops {
+		p, err := a.ResolveSecrets(h)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// ResolveChain looks up the hops of c and fills in their secrets.
+func (a *App) ResolveChain(c proxy.Chain) ([]proxy.Proxy, error) {
+	hops, err := a.Dialer.Resolve(c)
+	if err != nil {
+		return nil, err
+	}
+	return a.resolveHops(hops)
+}
+
+// UnlockVault unlocks (or, the first time, initializes) the vault and moves
+// any passwords still stored in plain text into it.
+func (a *App) UnlockVault(passphrase string) error {
+	if err := a.Vault.Unlock(passphrase); err != nil {
+		return err
+	}
+	a.settingsMu.RLock()
+	plain := a.savedSettings.Listeners.SOCKSPass != ""
+	a.settingsMu.RUnlock()
+	if plain {
+		if err := a.UpdateSettings(func(*config.Settings) {}); err != nil {
+			return err
+		}
+	}
+	for _, p := range a.Proxies.List() {
+		if p.Pass == "" {
+			continue
+		}
+		sealed, err := a.SealProxy(p)
+		if err != nil {
+			return err
+		}
+		if err := a.Proxies.Update(p.ID, sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
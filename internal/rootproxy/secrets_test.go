@@ -0,0 +1,69 @@
+package rootproxy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lily0ng/RootProxy/internal/proxy"
+	"github.com/lily0ng/RootProxy/internal/vault"
+)
+
+// vaultSize is the length of the sealed secrets, which only changes when
+// secrets are added or removed.
+func vaultSize(t *testing.T, dir string) int {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, vault.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ Ciphertext []byte }
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return len(v.Ciphertext)
+}
+
+func TestAddProxyLeavesNoOrphanedSecret(t *testing.T) {
+	dir := t.TempDir()
+	app, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	p := proxy.Proxy{Name: "lab", Type: proxy.TypeSOCKS5, Host: "127.0.0.1", Port: 1080, Auth: proxy.AuthBasic, User: "op", Pass: "secret"}
+	if err := app.AddProxy(p); err != nil {
+		t.Fatal(err)
+	}
+	added, _ := app.Proxies.GetByName("lab")
+	if added.Pass != "" || added.PassRef == "" {
+		t.Fatalf("password not sealed: %+v", added)
+	}
+
+	before := vaultSize(t, dir)
+	dup := p
+	dup.Pass = "other"
+	if err := app.AddProxy(dup); err == nil {
+		t.Fatal("duplicate name accepted")
+	}
+	if after := vaultSize(t, dir); after != before {
+		t.Errorf("rejected proxy left its password in the vault (%d -> %d bytes)", before, after)
+	}
+
+	// a new user gets a new reference and the old one goes
+	upd := added
+	upd.User, upd.Pass, upd.PassRef = "admin", "hunter2", ""
+	if err := app.UpdateProxy(added.ID, upd); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := app.Proxies.GetByID(added.ID)
+	if _, err := app.Vault.Get(added.PassRef); err == nil {
+		t.Error("old password left in the vault")
+	}
+	if got, err := app.ResolveSecrets(updated); err != nil || got.Pass != "hunter2" {
+		t.Errorf("resolved %q, %v", got.Pass, err)
+	}
+}
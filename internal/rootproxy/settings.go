@@ -0,0 +1,87 @@
+package rootproxy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+)
+
+// CurrentSettings returns the settings in effect, including command line
+// overrides.
+func (a *App) CurrentSettings() config.Settings {
+	a.settingsMu.RLock()
+	defer a.settingsMu.RUnlock()
+	return *a.Settings
+}
+
+// UpdateSettings applies fn to the settings in effect and to the saved
+// settings, then saves them. Command line overrides of fields fn leaves
+// alone stay in effect until exit without being saved. A SOCKS listener
+// password is saved in the vault, as proxy passwords are.
+func (a *App) UpdateSettings(fn func(*config.Settings)) error {
+	a.settingsMu.Lock()
+	live, saved := *a.Settings, a.savedSettings
+	prevRef := saved.Listeners.SOCKSPassRef
+	fn(&live)
+	fn(&saved)
+	if err := validateSettings(live); err != nil {
+		a.settingsMu.Unlock()
+		return err
+	}
+	if err := a.sealListener(&saved.Listeners); err != nil {
+		a.settingsMu.Unlock()
+		return err
+	}
+	if prevRef != "" && saved.Listeners.SOCKSPassRef == "" {
+		_ = a.Vault.Delete(prevRef)
+	}
+	live.Listeners.SOCKSPassRef = saved.Listeners.SOCKSPassRef
+	*a.Settings, a.savedSettings = live, saved
+	a.settingsMu.Unlock()
+	return a.Save()
+}
+
+// socksPassRef is where the SOCKS listener password lives in the vault.
+const socksPassRef = "listener/socks"
+
+// sealListener moves ls.SOCKSPass into the vault, like SealProxy, and drops
+// the reference once there is no user.
+func (a *App) sealListener(ls *config.ListenerSettings) error {
+	if ls.SOCKSUser == "" {
+		ls.SOCKSPass, ls.SOCKSPassRef = "", ""
+		return nil
+	}
+	if ls.SOCKSPass == "" || !a.Vault.Initialized() {
+		return nil
+	}
+	if err := a.Vault.Put(socksPassRef, []byte(ls.SOCKSPass)); err != nil {
+		return err
+	}
+	ls.SOCKSPass, ls.SOCKSPassRef = "", socksPassRef
+	return nil
+}
+
+// SOCKSCredentials returns the user and password of the SOCKS listener,
+// reading the password from the vault when it was saved there.
+func (a *App) SOCKSCredentials() (string, string, error) {
+	ls := a.CurrentSettings().Listeners
+	if ls.SOCKSPass != "" || ls.SOCKSPassRef == "" {
+		return ls.SOCKSUser, ls.SOCKSPass, nil
+	}
+	b, err := a.Vault.Get(ls.SOCKSPassRef)
+	if err != nil {
+		return "", "", fmt.Errorf("socks listener password: %w", err)
+	}
+	return ls.SOCKSUser, string(b), nil
+}
+
+func validateSettings(s config.Settings) error {
+	if s.Listeners.Upstream == config.UpstreamChain && s.Listeners.UpstreamChain == "" {
+		return errors.New("chain upstream needs a chain name")
+	}
+	if s.Listeners.SOCKSPass != "" && s.Listeners.SOCKSUser == "" {
+		return errors.New("socks password set without a user")
+	}
+	return nil
+}
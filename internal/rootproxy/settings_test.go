@@ -0,0 +1,96 @@
+package rootproxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/state"
+	"github.com/lily0ng/RootProxy/internal/vault"
+)
+
+func TestUpdateSettingsKeepsOverridesUnsaved(t *testing.T) {
+	dir := t.TempDir()
+	app, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Settings.ProbeTarget = "10.10.10.5:80" // as from --probe
+
+	err = app.UpdateSettings(func(s *config.Settings) {
+		s.EchoURL = "http://echo.lab/get"
+		s.Listeners.HTTPAddr = "127.0.0.1:8118"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := app.CurrentSettings(); got.ProbeTarget != "10.10.10.5:80" || got.EchoURL != "http://echo.lab/get" {
+		t.Errorf("settings in effect = %+v", got)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reopened.CurrentSettings()
+	if got.EchoURL != "http://echo.lab/get" || got.Listeners.HTTPAddr != "127.0.0.1:8118" {
+		t.Errorf("update not saved: %+v", got)
+	}
+	if got.ProbeTarget != "" {
+		t.Errorf("command line override saved: %q", got.ProbeTarget)
+	}
+
+	if err := app.UpdateSettings(func(s *config.Settings) { s.Listeners.Upstream = config.UpstreamChain }); err == nil {
+		t.Error("chain upstream without a name accepted")
+	}
+}
+
+func TestSOCKSPasswordSavedInVault(t *testing.T) {
+	dir := t.TempDir()
+	app, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.UpdateSettings(func(s *config.Settings) {
+		s.Listeners.SOCKSUser, s.Listeners.SOCKSPass = "op", "hunter2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a vault the password stays in the settings, like proxy passwords
+	if err := app.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, state.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatal("socks password saved in plain text after the vault was set up")
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := reopened.SOCKSCredentials(); !errors.Is(err, vault.ErrLocked) {
+		t.Errorf("credentials from a locked vault: %v", err)
+	}
+	if err := reopened.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if user, pass, err := reopened.SOCKSCredentials(); err != nil || user != "op" || pass != "hunter2" {
+		t.Errorf("credentials = %q %q %v", user, pass, err)
+	}
+
+	// dropping the user drops the password from the vault
+	if err := reopened.UpdateSettings(func(s *config.Settings) { s.Listeners.SOCKSUser = "" }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Vault.Get(socksPassRef); err == nil {
+		t.Error("socks password left in the vault")
+	}
+}
@@ -0,0 +1,155 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/cert"
+	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/proxy"
+)
+
+// SchemaVersion is bumped whenever File changes shape; Load migrates
+// anything older.
+const SchemaVersion = 2
+
+const FileName = "state.json"
+
+type File struct {
+	Version       int                     `json:"version"`
+	Settings      config.Settings         `json:"settings"`
+	Proxies       []proxy.Proxy           `json:"proxies"`
+	ActiveProxy   string                  `json:"active_proxy"`
+	Chains        []proxy.Chain           `json:"chains"`
+	Profiles      []config.Profile        `json:"profiles"`
+	ActiveProfile string                  `json:"active_profile"`
+	Routing       []config.RoutingRule    `json:"routing"`
+	Security      config.SecuritySettings `json:"security"`
+	Certs         []cert.Certificate      `json:"certs"`
+}
+
+// DefaultDir is $XDG_CONFIG_HOME/rootproxy (~/.config/rootproxy on Linux).
+func DefaultDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "rootproxy"), nil
+}
+
+// Load reads the state file in dir. The bool is false when no state has
+// been saved yet.
+func Load(dir string) (File, bool, error) {
+	b, err := os.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return File{}, false, nil
+	}
+	if err != nil {
+		return File{}, false, err
+	}
+	if b, err = migrateRaw(b); err != nil {
+		return File{}, false, fmt.Errorf("%s: %w", FileName, err)
+	}
+	// fields missing from older files keep their defaults
+	f := File{Settings: *config.DefaultSettings()}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return File{}, false, fmt.Errorf("%s: %w", FileName, err)
+	}
+	if err := migrate(&f); err != nil {
+		return File{}, false, err
+	}
+	return f, true, nil
+}
+
+func Save(dir string, f File) error {
+	f.Version = SchemaVersion
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, FileName), b, 0o600)
+}
+
+// WriteFileAtomic writes to a temp file in the same directory and renames it
+// over path, so readers never observe a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func migrate(f *File) error {
+	if f.Version > SchemaVersion {
+		return fmt.Errorf("%s: schema version %d is newer than supported version %d", FileName, f.Version, SchemaVersion)
+	}
+	if f.Version < SchemaVersion {
+		f.Version = SchemaVersion
+	}
+	return nil
+}
+
+// migrateRaw rewrites what the current types no longer decode: before
+// version 2, health check durations were saved as nanoseconds.
+func migrateRaw(b []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	var version int
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, err
+		}
+	}
+	if version >= 2 {
+		return b, nil
+	}
+	var settings, hc map[string]json.RawMessage
+	if err := json.Unmarshal(doc["settings"], &settings); err != nil || settings == nil {
+		return b, nil
+	}
+	if err := json.Unmarshal(settings["HealthCheck"], &hc); err != nil || hc == nil {
+		return b, nil
+	}
+	for _, k := range []string{"Interval", "Timeout"} {
+		var ns int64
+		if err := json.Unmarshal(hc[k], &ns); err == nil {
+			hc[k], _ = json.Marshal(time.Duration(ns).String())
+		}
+	}
+	var err error
+	if settings["HealthCheck"], err = json.Marshal(hc); err != nil {
+		return nil, err
+	}
+	if doc["settings"], err = json.Marshal(settings); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
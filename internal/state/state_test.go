@@ -0,0 +1,41 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadMigratesHealthCheckDurations(t *testing.T) {
+	dir := t.TempDir()
+	v1 := `{"version": 1, "settings": {"Theme": "htb-dark",
+		"HealthCheck": {"Interval": 60000000000, "Concurrency": 4, "Timeout": 3000000000, "Rise": 2, "Fall": 3}}}`
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(v1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, ok, err := Load(dir)
+	if err != nil || !ok {
+		t.Fatalf("load: %v, %v", ok, err)
+	}
+	hc := f.Settings.HealthCheck
+	if hc.Interval != time.Minute || hc.Timeout != 3*time.Second || hc.Concurrency != 4 {
+		t.Fatalf("health check = %+v", hc)
+	}
+
+	if err := Save(dir, f); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, FileName))
+	if !strings.Contains(string(b), `"Interval": "1m0s"`) || !strings.Contains(string(b), `"Timeout": "3s"`) {
+		t.Errorf("durations not saved as strings:\n%s", b)
+	}
+	again, _, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Settings.HealthCheck != hc {
+		t.Errorf("reloaded %+v, want %+v", again.Settings.HealthCheck, hc)
+	}
+}
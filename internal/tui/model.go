@@ -8,6 +8,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
+	"github.com/lily0ng/RootProxy/internal/events"
 	"github.com/lily0ng/RootProxy/internal/proxy"
 	"github.com/lily0ng/RootProxy/internal/rootproxy"
 )
@@ -26,10 +27,23 @@ const (
 	screenAdvanced
 	screenSettings
 	screenProxyDashboard
+	screenLogs
 )
 
 type proxyTestMsg proxy.TestResult
 
+type vaultUnlockMsg struct{ err error }
+
+type speedTestMsg proxy.SpeedTestResult
+
+type logsTickMsg struct{}
+
+type chainTestMsg struct {
+	name string
+	res  proxy.ChainTestResult
+	err  error
+}
+
 type Model struct {
 	app   *rootproxy.App
 	theme Theme
@@ -44,6 +58,25 @@ type Model struct {
 	latencyText     string
 
 	helpVisible bool
+
+	vaultPrompt bool
+	vaultInput  []rune
+	vaultStatus string
+
+	speedText string
+
+	chainCursor  int
+	chainTesting bool
+	chainTest    *chainTestMsg
+
+	logsTicking bool
+	logsLevel   events.Level
+	logsTopic   string
+
+	tokenCursor int
+	// tokenRevoke holds the ID of the token awaiting confirmation.
+	tokenRevoke string
+	tokenStatus string
 }
 
 func NewModel(app *rootproxy.App) Model {
@@ -80,6 +113,35 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else {
 			m.statusText = "DISCONNECTED"
 			m.latencyText = "-"
+			if tr.Stage != "" {
+				m.latencyText = string(tr.Stage) + " failed"
+			}
+		}
+		return m, nil
+	case speedTestMsg:
+		st := proxy.SpeedTestResult(msg)
+		if st.OK {
+			m.speedText = fmt.Sprintf("%.2f MB/s, %d bytes, ttfb %s",
+				st.BytesPerSec/1e6, st.Bytes, st.TTFB.Round(time.Millisecond))
+		} else {
+			m.speedText = "failed: " + st.Error
+		}
+		return m, nil
+	case logsTickMsg:
+		if m.screen != screenLogs {
+			m.logsTicking = false
+			return m, nil
+		}
+		return m, logsTick()
+	case chainTestMsg:
+		m.chainTesting = false
+		m.chainTest = &msg
+		return m, nil
+	case vaultUnlockMsg:
+		if msg.err != nil {
+			m.vaultStatus = "unlock failed: " + msg.err.Error()
+		} else {
+			m.vaultStatus = "unlocked"
 		}
 		return m, nil
 	}
@@ -98,12 +160,22 @@ func (m Model) View() string {
 }
 
 func (m Model) handleKey(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.vaultPrompt {
+		return m.handleVaultKey(k)
+	}
 	switch k.String() {
 	case "q", "esc", "f10":
 		return m, tea.Quit
 	case "f1":
 		m.helpVisible = !m.helpVisible
 		return m, nil
+	case "f3":
+		m.screen = screenLogs
+		if m.logsTicking {
+			return m, nil
+		}
+		m.logsTicking = true
+		return m, logsTick()
 	case "f4":
 		return m, m.testActiveProxyCmd()
 	case "ctrl+p":
@@ -121,6 +193,96 @@ func (m Model) handleKey(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "ctrl+s":
 		m.screen = screenSettings
 		return m, nil
+	case "ctrl+u":
+		if m.app.Vault.Unlocked() {
+			m.app.Vault.Lock()
+			m.vaultStatus = "locked"
+			return m, nil
+		}
+		m.vaultPrompt = true
+		m.vaultInput = nil
+		return m, nil
+	case "p":
+		if m.screen != screenProfiles {
+			return m, nil
+		}
+		name := m.app.Profiles.Active()
+		if m.app.Scheduler.Paused(name) {
+			_ = m.app.Scheduler.Resume(name)
+		} else {
+			_ = m.app.Scheduler.Pause(name)
+		}
+		return m, nil
+	case "s":
+		if m.screen != screenAdvanced || m.speedText == "running..." {
+			return m, nil
+		}
+		cmd := m.speedTestActiveProxyCmd()
+		if cmd != nil {
+			m.speedText = "running..."
+		}
+		return m, cmd
+	case "up", "down":
+		var cursor *int
+		var n int
+		switch m.screen {
+		case screenChains:
+			cursor, n = &m.chainCursor, len(m.app.Chains.List())
+		case screenSecurity:
+			cursor, n = &m.tokenCursor, len(m.app.Tokens.List())
+			m.tokenRevoke = ""
+		default:
+			return m, nil
+		}
+		if k.String() == "up" && *cursor > 0 {
+			*cursor--
+		}
+		if k.String() == "down" && *cursor < n-1 {
+			*cursor++
+		}
+		return m, nil
+	case "x":
+		if m.screen != screenSecurity {
+			return m, nil
+		}
+		tokens := m.app.Tokens.List()
+		if m.tokenCursor < len(tokens) {
+			m.tokenRevoke = tokens[m.tokenCursor].ID
+		}
+		return m, nil
+	case "y":
+		if m.screen != screenSecurity || m.tokenRevoke == "" {
+			return m, nil
+		}
+		if err := m.app.Tokens.Revoke(m.tokenRevoke); err != nil {
+			m.tokenStatus = "revoke failed: " + err.Error()
+		} else {
+			m.tokenStatus = "revoked " + m.tokenRevoke
+		}
+		m.tokenRevoke = ""
+		if n := len(m.app.Tokens.List()); m.tokenCursor >= n && n > 0 {
+			m.tokenCursor = n - 1
+		}
+		return m, nil
+	case "l":
+		if m.screen != screenLogs {
+			return m, nil
+		}
+		m.logsLevel = nextOf([]events.Level{"", events.LevelInfo, events.LevelWarn, events.LevelError}, m.logsLevel)
+		return m, nil
+	case "f":
+		if m.screen != screenLogs {
+			return m, nil
+		}
+		m.logsTopic = nextOf(append([]string{""}, events.Topics...), m.logsTopic)
+		return m, nil
+	case "t":
+		if m.screen != screenChains || m.chainTesting {
+			return m, nil
+		}
+		cmd := m.testChainCmd()
+		m.chainTesting = cmd != nil
+		return m, cmd
 	case "1":
 		m.screen = screenProxyDashboard
 		return m, nil
@@ -155,15 +317,100 @@ func (m Model) handleKey(k tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m Model) handleVaultKey(k tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch k.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.vaultPrompt = false
+		m.vaultInput = nil
+		return m, nil
+	case tea.KeyEnter:
+		pass := string(m.vaultInput)
+		m.vaultPrompt = false
+		m.vaultInput = nil
+		m.vaultStatus = "unlocking..."
+		app := m.app
+		return m, func() tea.Msg {
+			return vaultUnlockMsg{err: app.UnlockVault(pass)}
+		}
+	case tea.KeyBackspace:
+		if len(m.vaultInput) > 0 {
+			m.vaultInput = m.vaultInput[:len(m.vaultInput)-1]
+		}
+		return m, nil
+	case tea.KeyRunes, tea.KeySpace:
+		m.vaultInput = append(m.vaultInput, k.Runes...)
+		return m, nil
+	}
+	return m, nil
+}
+
 func (m Model) testActiveProxyCmd() tea.Cmd {
 	p, ok := m.app.Proxies.GetByName(m.activeProxyName)
 	if !ok {
 		return nil
 	}
+	p, err := m.app.ResolveSecrets(p)
+	if err != nil {
+		return func() tea.Msg {
+			return proxyTestMsg(proxy.TestResult{Stage: proxy.StageAuth, Error: err.Error()})
+		}
+	}
+	opts := proxy.TestOptions{Target: m.app.CurrentSettings().ProbeTarget}
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
-		return proxyTestMsg(proxy.TestConnectivity(ctx, p))
+		tr := proxy.TestConnectivity(ctx, p, opts)
+		m.app.Monitor.RecordTest(p.Name, tr)
+		return proxyTestMsg(tr)
+	}
+}
+
+func (m Model) speedTestActiveProxyCmd() tea.Cmd {
+	p, ok := m.app.Proxies.GetByName(m.activeProxyName)
+	if !ok {
+		return nil
+	}
+	p, err := m.app.ResolveSecrets(p)
+	if err != nil {
+		return func() tea.Msg {
+			return speedTestMsg(proxy.SpeedTestResult{Error: err.Error()})
+		}
+	}
+	opts := proxy.SpeedTestOptions{URL: m.app.CurrentSettings().SpeedTestURL, Bytes: proxy.DefaultSpeedTestBytes}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		return speedTestMsg(proxy.SpeedTest(ctx, proxy.DialFunc(p), opts))
+	}
+}
+
+// logsTick refreshes the logs screen while it is shown.
+func logsTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return logsTickMsg{} })
+}
+
+// nextOf returns the element after cur in list, wrapping around.
+func nextOf[T comparable](list []T, cur T) T {
+	for i, v := range list {
+		if v == cur {
+			return list[(i+1)%len(list)]
+		}
+	}
+	return list[0]
+}
+
+func (m Model) testChainCmd() tea.Cmd {
+	chains := m.app.Chains.List()
+	if m.chainCursor >= len(chains) {
+		return nil
+	}
+	name := chains[m.chainCursor].Name
+	app := m.app
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		res, err := app.TestChain(ctx, name, app.CurrentSettings().ProbeTarget)
+		return chainTestMsg{name: name, res: res, err: err}
 	}
 }
 
@@ -183,6 +430,9 @@ func (m Model) renderHeader() string {
 }
 
 func (m Model) renderBody() string {
+	if m.vaultPrompt {
+		return renderVaultPrompt(m)
+	}
 	if m.helpVisible {
 		return m.renderHelp()
 	}
@@ -207,6 +457,8 @@ func (m Model) renderBody() string {
 		return renderAdvanced(m)
 	case screenSettings:
 		return renderSettings(m)
+	case screenLogs:
+		return renderLogs(m)
 	default:
 		return renderDashboard(m)
 	}
@@ -214,13 +466,18 @@ func (m Model) renderBody() string {
 
 func (m Model) renderFooter() string {
 	foot := lipgloss.NewStyle().Foreground(m.theme.Muted)
-	return foot.Render(" F1:Help  F2:QuickSwitch  F3:Logs  F4:Test  F10:Exit ")
+	text := " F1:Help  F2:QuickSwitch  F3:Logs  F4:Test  F10:Exit "
+	if m.vaultStatus != "" {
+		text += " Vault: " + m.vaultStatus + " "
+	}
+	return foot.Render(text)
 }
 
 func (m Model) renderHelp() string {
 	panel := panelStyle(m.theme)
 	text := "Quick Commands\n\n" +
 		"F1  - Show help\n" +
+		"F3  - Event log (l: level, f: topic)\n" +
 		"F4  - Test current proxy\n" +
 		"F10 - Exit\n\n" +
 		"Hotkeys\n\n" +
@@ -228,7 +485,8 @@ func (m Model) renderHelp() string {
 		"Ctrl+C - Cert manager\n" +
 		"Ctrl+R - Routing rules\n" +
 		"Ctrl+M - Monitoring\n" +
-		"Ctrl+S - Settings\n"
+		"Ctrl+S - Settings\n" +
+		"Ctrl+U - Unlock/lock vault\n"
 	return panel.Render(text)
 }
 
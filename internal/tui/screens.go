@@ -3,8 +3,12 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/lily0ng/RootProxy/internal/events"
+	"github.com/lily0ng/RootProxy/internal/monitor"
 )
 
 func renderProxyDashboard(m Model) string {
@@ -54,7 +58,19 @@ func renderProfiles(m Model) string {
 			marker = lipgloss.NewStyle().Foreground(m.theme.Success).Render("●")
 		}
 		b.WriteString(fmt.Sprintf("%s %s  chain=%v\n", marker, p.Name, p.Chain))
+		if p.Rotation.Scheduled() {
+			state := "running"
+			if m.app.Scheduler.Paused(p.Name) {
+				state = "paused"
+			}
+			b.WriteString(fmt.Sprintf("    rotation: %s every %s (%s)\n", p.Rotation.Mode, p.Rotation.Interval, state))
+		}
 	}
+	if events := m.app.Scheduler.Events(); len(events) > 0 {
+		ev := events[len(events)-1]
+		b.WriteString(fmt.Sprintf("\nLast rotation: %s -> %s at %s\n", ev.From, ev.To, ev.At.Local().Format("15:04:05")))
+	}
+	b.WriteString("\nTip: Press p to pause/resume rotation for the active profile.\n")
 	return panel.Render(b.String())
 }
 
@@ -65,17 +81,178 @@ func renderRouting(m Model) string {
 
 func renderChains(m Model) string {
 	panel := panelStyle(m.theme)
-	return panel.Render("Proxy Chains\n\nMulti-hop chain builder (up to 5 hops) is scaffolded here.")
+	var b strings.Builder
+	b.WriteString("Proxy Chains\n\n")
+	chains := m.app.Chains.List()
+	if len(chains) == 0 {
+		b.WriteString("No chains yet. Add one with POST /api/v1/chain/upsert (up to 5 hops).\n")
+		return panel.Render(b.String())
+	}
+	for i, c := range chains {
+		cursor := "  "
+		if i == m.chainCursor {
+			cursor = "> "
+		}
+		b.WriteString(fmt.Sprintf("%s%s: %s\n", cursor, c.Name, strings.Join(c.Hops, " -> ")))
+	}
+	b.WriteString("\n↑/↓ select, t test hop by hop\n")
+
+	switch {
+	case m.chainTesting:
+		b.WriteString("\nTesting...\n")
+	case m.chainTest != nil && m.chainTest.err != nil:
+		b.WriteString(fmt.Sprintf("\n%s: %s\n", m.chainTest.name, m.chainTest.err))
+	case m.chainTest != nil:
+		ok := lipgloss.NewStyle().Foreground(m.theme.Success)
+		fail := lipgloss.NewStyle().Foreground(m.theme.Danger)
+		b.WriteString(fmt.Sprintf("\n%s (%s)\n", m.chainTest.name, roundMS(m.chainTest.res.Latency)))
+		for _, h := range m.chainTest.res.Hops {
+			if h.OK {
+				b.WriteString(ok.Render(fmt.Sprintf("  %d %s  handshake %s  total %s", h.Hop, h.Name, roundMS(h.Handshake), roundMS(h.Cumulative))) + "\n")
+				continue
+			}
+			b.WriteString(fail.Render(fmt.Sprintf("  %d %s  %s failed: %s", h.Hop, h.Name, h.Stage, h.Error)) + "\n")
+		}
+	}
+	return panel.Render(b.String())
+}
+
+func renderLogs(m Model) string {
+	panel := panelStyle(m.theme)
+	var b strings.Builder
+	level, topic := string(m.logsLevel), m.logsTopic
+	if level == "" {
+		level = "all"
+	}
+	if topic == "" {
+		topic = "all"
+	}
+	b.WriteString(fmt.Sprintf("Event Log  [l] level: %s  [f] topic: %s\n\n", level, topic))
+	f := events.Filter{MinLevel: m.logsLevel}
+	if m.logsTopic != "" {
+		f.Topics = []string{m.logsTopic}
+	}
+	evs := m.app.Events.Events(f)
+	if len(evs) == 0 {
+		b.WriteString("No events yet.\n")
+		return panel.Render(b.String())
+	}
+	rows := max(5, m.height-12)
+	if len(evs) > rows {
+		evs = evs[len(evs)-rows:]
+	}
+	styles := map[events.Level]lipgloss.Style{
+		events.LevelDebug: lipgloss.NewStyle().Foreground(m.theme.Muted),
+		events.LevelInfo:  lipgloss.NewStyle().Foreground(m.theme.Fg),
+		events.LevelWarn:  lipgloss.NewStyle().Foreground(m.theme.Accent),
+		events.LevelError: lipgloss.NewStyle().Foreground(m.theme.Danger),
+	}
+	for _, e := range evs {
+		line := fmt.Sprintf("%s %-5s %-8s %s", e.At.Local().Format("15:04:05"), e.Level, e.Topic, e.Message)
+		b.WriteString(styles[e.Level].Render(line) + "\n")
+	}
+	return panel.Render(b.String())
 }
 
 func renderMonitoring(m Model) string {
 	panel := panelStyle(m.theme)
-	return panel.Render("Monitoring & Analytics\n\nTraffic graphs, logs, and latency charts are scaffolded here.")
+	var b strings.Builder
+	b.WriteString("Monitoring & Analytics\n\n")
+	snap := m.app.Monitor.Snapshot()
+	if len(snap) == 0 {
+		b.WriteString("No proxy tests recorded yet. Press F4 to test the active proxy.\n")
+		return panel.Render(b.String())
+	}
+	width := max(10, min(60, m.width-40))
+	for _, pm := range snap {
+		h, ok := m.app.Monitor.History(pm.Name)
+		if !ok {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("%s\n  %s\n", pm.Name, sparkline(m, h.Samples, width)))
+		b.WriteString(fmt.Sprintf("  p50 %s  p90 %s  p99 %s  jitter %s  up 1h %s  24h %s\n",
+			roundMS(h.P50), roundMS(h.P90), roundMS(h.P99), roundMS(h.Jitter),
+			percent(h.Availability1h), percent(h.Availability24h)))
+		if pm.ExitIP != "" {
+			b.WriteString(fmt.Sprintf("  exit %s  %s\n", pm.ExitIP, pm.Anonymity))
+		}
+		b.WriteString("\n")
+	}
+	return panel.Render(b.String())
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws the latest samples scaled to the slowest of them;
+// failures are a red cross.
+func sparkline(m Model, samples []monitor.Sample, width int) string {
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	var maxLat time.Duration
+	for _, s := range samples {
+		if s.OK && s.Latency > maxLat {
+			maxLat = s.Latency
+		}
+	}
+	ok := lipgloss.NewStyle().Foreground(m.theme.Success)
+	fail := lipgloss.NewStyle().Foreground(m.theme.Danger)
+	var b strings.Builder
+	for _, s := range samples {
+		if !s.OK {
+			b.WriteString(fail.Render("x"))
+			continue
+		}
+		i := 0
+		if maxLat > 0 {
+			i = int(s.Latency * time.Duration(len(sparkBlocks)-1) / maxLat)
+		}
+		b.WriteString(ok.Render(string(sparkBlocks[i])))
+	}
+	return b.String()
+}
+
+func roundMS(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func percent(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", *v)
 }
 
 func renderSecurity(m Model) string {
 	panel := panelStyle(m.theme)
-	return panel.Render("Security Settings\n\nDoH/DoT, leak protection, kill switch are scaffolded here.")
+	var b strings.Builder
+	b.WriteString("Security Settings\n\nAPI tokens\n\n")
+	tokens := m.app.Tokens.List()
+	if len(tokens) == 0 {
+		b.WriteString("No tokens; the API is open on loopback only.\nCreate one with: rootproxy token create -name <name> -scopes read\n")
+	}
+	for i, t := range tokens {
+		cursor := "  "
+		if i == m.tokenCursor {
+			cursor = "> "
+		}
+		scopes := make([]string, len(t.Scopes))
+		for j, sc := range t.Scopes {
+			scopes[j] = string(sc)
+		}
+		b.WriteString(fmt.Sprintf("%s%s  %s  %s  %s\n", cursor, t.ID, t.Name, strings.Join(scopes, ","), t.CreatedAt.Format("2006-01-02")))
+	}
+	if len(tokens) > 0 {
+		b.WriteString("\n↑/↓ select, x revoke\n")
+	}
+	if m.tokenRevoke != "" {
+		warn := lipgloss.NewStyle().Foreground(m.theme.Danger)
+		b.WriteString("\n" + warn.Render("Revoke token "+m.tokenRevoke+"? y to confirm") + "\n")
+	} else if m.tokenStatus != "" {
+		b.WriteString("\n" + m.tokenStatus + "\n")
+	}
+	b.WriteString("\nDoH/DoT, leak protection, kill switch are scaffolded here.")
+	return panel.Render(b.String())
 }
 
 func renderIntegrations(m Model) string {
@@ -83,20 +260,37 @@ func renderIntegrations(m Model) string {
 	return panel.Render("Integrations\n\nBurp, Nmap, Metasploit routing integrations are scaffolded here.")
 }
 
+func renderVaultPrompt(m Model) string {
+	panel := panelStyle(m.theme)
+	title := "Unlock Vault"
+	if !m.app.Vault.Initialized() {
+		title = "Set Vault Passphrase"
+	}
+	mask := strings.Repeat("*", len(m.vaultInput))
+	return panel.Render(title + "\n\nPassphrase: " + mask + "\n\nEnter to confirm, Esc to cancel")
+}
+
 func renderAdvanced(m Model) string {
 	panel := panelStyle(m.theme)
-	return panel.Render("Advanced Tools\n\nScraper / validator / bruteforcer stubs are scaffolded here.")
+	var b strings.Builder
+	b.WriteString("Advanced Tools\n\n")
+	b.WriteString("[s] Speed test active proxy\n\n")
+	if m.speedText != "" {
+		b.WriteString("Speed test: " + m.speedText + "\n\n")
+	}
+	b.WriteString("Scraper / validator / bruteforcer stubs are scaffolded here.")
+	return panel.Render(b.String())
 }
 
 func renderSettings(m Model) string {
 	panel := panelStyle(m.theme)
-	return panel.Render("Settings\n\nTheme: " + m.app.Settings.Theme + "\n")
+	return panel.Render("Settings\n\nTheme: " + m.app.CurrentSettings().Theme + "\n")
 }
 
 // munal code with go ( for rendercontriolshelp )
 
 func renderControlsHelp(m Model) string {
-	helpStyle := lipgloss.NewStyle().Foreground(m.theme.Faint)
+	helpStyle := lipgloss.NewStyle().Foreground(m.theme.Muted)
 	helpText := "Controls: ↑/↓ Navigate | Enter Select | F1 Dashboard | F2 Proxies | F3 Certs | F4 Test Proxy | F5 Profiles | F6 Routing | F7 Chains | F8 Monitoring | F9 Security | F10 Integrations | F11 Advanced | F12 Settings | q Quit"
 	return helpStyle.Render(helpText)
 }
@@ -0,0 +1,247 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/lily0ng/RootProxy/internal/state"
+)
+
+const FileName = "vault.json"
+
+var (
+	ErrLocked        = errors.New("vault is locked")
+	ErrNotFound      = errors.New("secret not found")
+	ErrBadPassphrase = errors.New("wrong vault passphrase")
+)
+
+const (
+	fileVersion = 1
+	keyLen      = 32
+	saltLen     = 16
+)
+
+type kdfParams struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+type sealed struct {
+	Version    int       `json:"version"`
+	KDF        kdfParams `json:"kdf"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// Vault keeps secrets encrypted at rest with AES-256-GCM under a key derived
+// from a passphrase with scrypt. Secrets are only readable while unlocked.
+type Vault struct {
+	mu      sync.Mutex
+	path    string
+	sealed  *sealed
+	key     []byte
+	secrets map[string][]byte
+}
+
+// Open reads the vault file at path, if any. The vault starts locked. An
+// empty path keeps the vault in memory only.
+func Open(path string) (*Vault, error) {
+	v := &Vault{path: path}
+	if path == "" {
+		return v, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s sealed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	if s.Version > fileVersion {
+		return nil, fmt.Errorf("%s: version %d is newer than supported version %d", FileName, s.Version, fileVersion)
+	}
+	if s.KDF.Name != "scrypt" {
+		return nil, fmt.Errorf("%s: unsupported kdf: %s", FileName, s.KDF.Name)
+	}
+	v.sealed = &s
+	return v, nil
+}
+
+func (v *Vault) Initialized() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.sealed != nil
+}
+
+func (v *Vault) Unlocked() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.key != nil
+}
+
+// Unlock derives the key from passphrase and decrypts the secrets. The
+// first unlock of an uninitialized vault sets its passphrase.
+func (v *Vault) Unlock(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("vault passphrase required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed == nil {
+		salt := make([]byte, saltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		params := kdfParams{Name: "scrypt", N: 1 << 15, R: 8, P: 1, Salt: salt}
+		key, err := deriveKey(passphrase, params)
+		if err != nil {
+			return err
+		}
+		v.key = key
+		v.secrets = make(map[string][]byte)
+		v.sealed = &sealed{Version: fileVersion, KDF: params}
+		return v.persist()
+	}
+
+	key, err := deriveKey(passphrase, v.sealed.KDF)
+	if err != nil {
+		return err
+	}
+	plain, err := open(key, v.sealed.Nonce, v.sealed.Ciphertext)
+	if err != nil {
+		return ErrBadPassphrase
+	}
+	secrets := make(map[string][]byte)
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return err
+	}
+	v.key = key
+	v.secrets = secrets
+	return nil
+}
+
+func (v *Vault) Lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, s := range v.secrets {
+		wipe(s)
+	}
+	wipe(v.key)
+	v.key = nil
+	v.secrets = nil
+}
+
+func (v *Vault) Get(ref string) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return nil, ErrLocked
+	}
+	s, ok := v.secrets[ref]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), s...), nil
+}
+
+func (v *Vault) Put(ref string, secret []byte) error {
+	if ref == "" {
+		return errors.New("secret ref required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	v.secrets[ref] = append([]byte(nil), secret...)
+	return v.persist()
+}
+
+func (v *Vault) Delete(ref string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	if _, ok := v.secrets[ref]; !ok {
+		return ErrNotFound
+	}
+	delete(v.secrets, ref)
+	return v.persist()
+}
+
+// persist re-encrypts every secret under a fresh nonce. Callers hold v.mu.
+func (v *Vault) persist() error {
+	plain, err := json.Marshal(v.secrets)
+	if err != nil {
+		return err
+	}
+	defer wipe(plain)
+	nonce, ct, err := seal(v.key, plain)
+	if err != nil {
+		return err
+	}
+	v.sealed.Nonce, v.sealed.Ciphertext = nonce, ct
+	if v.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(v.sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFileAtomic(v.path, b, 0o600)
+}
+
+func deriveKey(passphrase string, p kdfParams) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, keyLen)
+}
+
+func seal(key, plain []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func open(key, nonce, ct []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lily0ng/RootProxy/internal/auth"
+	"github.com/lily0ng/RootProxy/internal/events"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+type tokenKey struct{}
+
+// tokenFrom returns the token a request was authenticated with, if any.
+func tokenFrom(ctx context.Context) (auth.Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(auth.Token)
+	return t, ok
+}
+
+// requiredScopes maps a request to the scopes its token needs. Reads need
+// read; writes need the scope of their area, with proxy:write covering
+// everything that is not security or certificates. Revealing secrets and
+// reading private keys count as writes of their area.
+func requiredScopes(r *http.Request) []auth.Scope {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	var scopes []auth.Scope
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scopes = append(scopes, auth.ScopeRead)
+		if path == "/cert/key" {
+			scopes = append(scopes, auth.ScopeCertWrite)
+		}
+	default:
+		switch {
+		case strings.HasPrefix(path, "/security/"), strings.HasPrefix(path, "/vault/"):
+			scopes = append(scopes, auth.ScopeSecurityWrite)
+		case strings.HasPrefix(path, "/cert/"):
+			scopes = append(scopes, auth.ScopeCertWrite)
+		default:
+			scopes = append(scopes, auth.ScopeProxyWrite)
+		}
+	}
+	if r.URL.Query().Get("reveal") == "true" {
+		scopes = append(scopes, auth.ScopeSecurityWrite)
+	}
+	return scopes
+}
+
+// authMiddleware checks bearer tokens while any token exists, or always
+// when required is set, so revoking the last token locks the API rather
+// than opening it.
+func authMiddleware(app *rootproxy.App, required bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !required && !app.Tokens.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			deny := func(status int, challenge, reason string) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, reason, status)
+				app.Events.Publish(events.LevelWarn, events.TopicAPI,
+					fmt.Sprintf("%s %s denied: %s", r.Method, r.URL.Path, reason),
+					map[string]string{"method": r.Method, "path": r.URL.Path, "remote": r.RemoteAddr})
+			}
+
+			secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				deny(http.StatusUnauthorized, `Bearer realm="rootproxy"`, "bearer token required")
+				return
+			}
+			t, ok := app.Tokens.Authenticate(strings.TrimSpace(secret))
+			if !ok {
+				deny(http.StatusUnauthorized, `Bearer realm="rootproxy", error="invalid_token"`, "invalid token")
+				return
+			}
+			for _, sc := range requiredScopes(r) {
+				if !t.Has(sc) {
+					deny(http.StatusForbidden, fmt.Sprintf(`Bearer realm="rootproxy", error="insufficient_scope", scope=%q`, sc),
+						fmt.Sprintf("token %s lacks scope %s", t.Name, sc))
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, t)))
+		})
+	}
+}
+
+// isLoopback reports whether a listen address only accepts local
+// connections. An empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
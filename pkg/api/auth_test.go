@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lily0ng/RootProxy/internal/auth"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           []auth.Scope
+	}{
+		{"GET", "/api/v1/proxy/list", []auth.Scope{auth.ScopeRead}},
+		{"GET", "/api/v1/proxy/list?reveal=true", []auth.Scope{auth.ScopeRead, auth.ScopeSecurityWrite}},
+		{"GET", "/api/v1/settings?reveal=true", []auth.Scope{auth.ScopeRead, auth.ScopeSecurityWrite}},
+		{"GET", "/api/v1/cert/list", []auth.Scope{auth.ScopeRead}},
+		{"GET", "/api/v1/cert/key?name=api", []auth.Scope{auth.ScopeRead, auth.ScopeCertWrite}},
+		{"GET", "/api/v1/vault/status", []auth.Scope{auth.ScopeRead}},
+		{"POST", "/api/v1/vault/unlock", []auth.Scope{auth.ScopeSecurityWrite}},
+		{"POST", "/api/v1/vault/lock", []auth.Scope{auth.ScopeSecurityWrite}},
+		{"POST", "/api/v1/security/set", []auth.Scope{auth.ScopeSecurityWrite}},
+		{"POST", "/api/v1/cert/add", []auth.Scope{auth.ScopeCertWrite}},
+		{"POST", "/api/v1/proxy/add", []auth.Scope{auth.ScopeProxyWrite}},
+		{"POST", "/api/v1/proxy/test?reveal=true", []auth.Scope{auth.ScopeProxyWrite, auth.ScopeSecurityWrite}},
+		{"DELETE", "/api/v1/proxy/remove/abc", []auth.Scope{auth.ScopeProxyWrite}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			got := requiredScopes(httptest.NewRequest(tt.method, tt.target, nil))
+			if len(got) != len(tt.want) {
+				t.Fatalf("scopes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("scopes = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// call sends a request straight to the server's handler.
+func call(srv *Server, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader("{}"))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	app := rootproxy.NewApp()
+	srv, err := NewServer("127.0.0.1:0", app, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := call(srv, "GET", "/api/v1/status", ""); rec.Code != http.StatusOK {
+		t.Fatalf("open API without tokens: %d", rec.Code)
+	}
+
+	_, reader, err := app.Tokens.Create("dashboard", []auth.Scope{auth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, method, target, token string
+		want                        int
+	}{
+		{"no token", "GET", "/api/v1/status", "", http.StatusUnauthorized},
+		{"unknown token", "GET", "/api/v1/status", "rp_unknown", http.StatusUnauthorized},
+		{"read", "GET", "/api/v1/status", reader, http.StatusOK},
+		{"write with read scope", "POST", "/api/v1/proxy/add", reader, http.StatusForbidden},
+		{"private key with read scope", "GET", "/api/v1/cert/key?name=api", reader, http.StatusForbidden},
+		{"reveal with read scope", "GET", "/api/v1/proxy/list?reveal=true", reader, http.StatusForbidden},
+		{"vault write with read scope", "POST", "/api/v1/vault/lock", reader, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call(srv, tt.method, tt.target, tt.token)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && !strings.Contains(rec.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+				t.Errorf("challenge = %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestLastTokenRevoked(t *testing.T) {
+	app := rootproxy.NewApp()
+	if _, err := NewServer("0.0.0.0:0", app, Options{}); err == nil {
+		t.Fatal("served a public address without tokens")
+	}
+	tok, secret, err := app.Tokens.Create("ci", []auth.Scope{auth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := NewServer("0.0.0.0:0", app, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewServer("127.0.0.1:0", app, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := call(public, "GET", "/api/v1/status", secret); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	if err := app.Tokens.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	// a public server locks rather than opening up; a loopback one opens
+	if rec := call(public, "GET", "/api/v1/status", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("public server after revoking the last token: %d", rec.Code)
+	}
+	if rec := call(public, "GET", "/api/v1/status", secret); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token on public server: %d", rec.Code)
+	}
+	if rec := call(local, "GET", "/api/v1/status", ""); rec.Code != http.StatusOK {
+		t.Errorf("loopback server after revoking the last token: %d", rec.Code)
+	}
+}
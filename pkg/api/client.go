@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client calls the REST API of a running RootProxy. It is what command line
+// tools build on, so they reach the local instance over its socket without
+// any configuration.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewClient connects to addr, given like --api: host:port, an http(s) URL,
+// or unix:///path. An empty addr means the socket at DefaultSocketPath.
+// A non-empty token is sent as a bearer token.
+func NewClient(addr, token string) *Client {
+	if addr == "" {
+		addr = "unix://"
+	}
+	c := &Client{base: addr, token: token, http: &http.Client{}}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if path == "" {
+			path = DefaultSocketPath()
+		}
+		var d net.Dialer
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				// a socket someone else created could collect our token
+				if token != "" {
+					fi, err := os.Lstat(path)
+					if err != nil {
+						return nil, err
+					}
+					if fi.Mode()&os.ModeSocket == 0 || !ownedBySelf(fi) {
+						return nil, fmt.Errorf("%s: not a socket owned by the current user; refusing to send credentials", path)
+					}
+				}
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		// the host is ignored by the dialer but still has to parse
+		c.base = "http://rootproxy"
+	} else if !strings.Contains(addr, "://") {
+		c.base = "http://" + addr
+	}
+	return c
+}
+
+// Do sends a request for path, such as /api/v1/status.
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lily0ng/RootProxy/internal/events"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+type eventsPage struct {
+	Events []events.Event `json:"events"`
+	// Next is the cursor to pass as since to get the following events.
+	Next uint64 `json:"next"`
+}
+
+// eventsHandler lists buffered events after the since cursor, filtered by
+// minimum level and topic.
+func eventsHandler(app *rootproxy.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseEventFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := eventsPage{Events: app.Events.Events(f), Next: f.Since}
+		if n := len(page.Events); n > 0 {
+			page.Next = page.Events[n-1].ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(page)
+	}
+}
+
+// eventStreamHandler pushes events matching the query's filter as
+// Server-Sent Events. A since cursor, or the Last-Event-ID header a
+// reconnecting EventSource sends, first replays the buffered events after it.
+func eventStreamHandler(app *rootproxy.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseEventFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		replay := r.URL.Query().Has("since")
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+				f.Since, replay = n, true
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// subscribe before replaying so nothing published in between is lost
+		live, cancel := app.Events.Subscribe(f, 64)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		last := f.Since
+		send := func(e events.Event) {
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, b)
+			last = e.ID
+		}
+		if replay {
+			for _, e := range app.Events.Events(f) {
+				send(e)
+			}
+		}
+		flusher.Flush()
+
+		ping := time.NewTicker(15 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-live:
+				if !ok {
+					return
+				}
+				if e.ID <= last {
+					continue
+				}
+				send(e)
+				flusher.Flush()
+			case <-ping.C:
+				// keeps idle connections from being dropped by intermediaries
+				_, _ = w.Write([]byte(": ping\n\n"))
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func parseEventFilter(r *http.Request) (events.Filter, error) {
+	q := r.URL.Query()
+	var f events.Filter
+	if qs := q.Get("since"); qs != "" {
+		n, err := strconv.ParseUint(qs, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid since: %s", qs)
+		}
+		f.Since = n
+	}
+	if qs := q.Get("level"); qs != "" {
+		lvl, err := events.ParseLevel(qs)
+		if err != nil {
+			return f, err
+		}
+		f.MinLevel = lvl
+	}
+	if qs := q.Get("limit"); qs != "" {
+		n, err := strconv.Atoi(qs)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid limit: %s", qs)
+		}
+		f.Limit = n
+	}
+	// topic=a,b and topic=a&topic=b both select several topics
+	for _, t := range q["topic"] {
+		for _, name := range strings.Split(t, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f.Topics = append(f.Topics, name)
+			}
+		}
+	}
+	return f, nil
+}
+
+// auditMiddleware publishes an api event for every request that is not a
+// plain read.
+func auditMiddleware(app *rootproxy.App) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			level := events.LevelInfo
+			if sw.status >= 400 {
+				level = events.LevelWarn
+			}
+			fields := map[string]string{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"status": strconv.Itoa(sw.status),
+				"remote": r.RemoteAddr,
+			}
+			if t, ok := tokenFrom(r.Context()); ok {
+				fields["token"] = t.Name
+			}
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				fields["client"] = r.TLS.PeerCertificates[0].Subject.CommonName
+			}
+			app.Events.Publish(level, events.TopicAPI,
+				fmt.Sprintf("%s %s -> %d", r.Method, r.URL.Path, sw.status), fields)
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps streaming handlers working behind the middleware.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lily0ng/RootProxy/internal/monitor"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+// metricsHandler serves the Prometheus text exposition format (0.0.4).
+func metricsHandler(app *rootproxy.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, app)
+		_ = bw.Flush()
+	}
+}
+
+func writeMetrics(w *bufio.Writer, app *rootproxy.App) {
+	header := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	sample := func(name string, labels []string, v float64) {
+		w.WriteString(name)
+		if len(labels) > 0 {
+			w.WriteByte('{')
+			for i := 0; i+1 < len(labels); i += 2 {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(labels[i])
+				w.WriteString(`="`)
+				w.WriteString(escapeLabel(labels[i+1]))
+				w.WriteByte('"')
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		w.WriteByte('\n')
+	}
+
+	snap := app.Monitor.Snapshot()
+	header("rootproxy_proxy_tests_total", "counter", "Proxy connectivity tests by result.")
+	for _, m := range snap {
+		sample("rootproxy_proxy_tests_total", []string{"proxy", m.Name, "result", "success"}, float64(m.Successes))
+		sample("rootproxy_proxy_tests_total", []string{"proxy", m.Name, "result", "failure"}, float64(m.Failures))
+	}
+	header("rootproxy_proxy_up", "gauge", "Whether the latest test of the proxy succeeded.")
+	for _, m := range snap {
+		sample("rootproxy_proxy_up", []string{"proxy", m.Name}, boolValue(m.LastOK))
+	}
+	header("rootproxy_proxy_last_latency_seconds", "gauge", "Latency of the latest proxy test.")
+	for _, m := range snap {
+		sample("rootproxy_proxy_last_latency_seconds", []string{"proxy", m.Name}, m.LastLatency.Seconds())
+	}
+	header("rootproxy_proxy_exit_info", "gauge", "Exit IP and anonymity level from the latest successful exit check.")
+	for _, m := range snap {
+		if m.ExitIP != "" {
+			sample("rootproxy_proxy_exit_info", []string{"proxy", m.Name, "exit_ip", m.ExitIP, "anonymity", string(m.Anonymity)}, 1)
+		}
+	}
+
+	header("rootproxy_proxy_test_latency_seconds", "histogram", "Latency of successful proxy tests.")
+	for _, h := range app.Monitor.Histograms() {
+		for i, le := range monitor.LatencyBuckets {
+			sample("rootproxy_proxy_test_latency_seconds_bucket", []string{"proxy", h.Proxy, "le", strconv.FormatFloat(le, 'g', -1, 64)}, float64(h.Counts[i]))
+		}
+		sample("rootproxy_proxy_test_latency_seconds_bucket", []string{"proxy", h.Proxy, "le", "+Inf"}, float64(h.Count))
+		sample("rootproxy_proxy_test_latency_seconds_sum", []string{"proxy", h.Proxy}, h.Sum)
+		sample("rootproxy_proxy_test_latency_seconds_count", []string{"proxy", h.Proxy}, float64(h.Count))
+	}
+
+	header("rootproxy_rotations_total", "counter", "Active proxy changes made by rotation.")
+	for _, rc := range app.Monitor.Rotations() {
+		trigger := "scheduled"
+		if rc.Manual {
+			trigger = "manual"
+		}
+		sample("rootproxy_rotations_total", []string{"profile", rc.Profile, "trigger", trigger}, float64(rc.Count))
+	}
+
+	header("rootproxy_active_profile_info", "gauge", "The active profile.")
+	sample("rootproxy_active_profile_info", []string{"profile", app.Profiles.Active()}, 1)
+	header("rootproxy_active_proxy_info", "gauge", "The active proxy.")
+	sample("rootproxy_active_proxy_info", []string{"proxy", app.Proxies.ActiveName()}, 1)
+
+	stats := app.Listeners.Stats()
+	header("rootproxy_listener_connections_total", "counter", "Connections accepted by local listeners.")
+	for _, st := range stats {
+		sample("rootproxy_listener_connections_total", []string{"kind", string(st.Kind)}, float64(st.ConnectionsTotal))
+	}
+	header("rootproxy_listener_active_connections", "gauge", "Open connections on local listeners.")
+	for _, st := range stats {
+		sample("rootproxy_listener_active_connections", []string{"kind", string(st.Kind)}, float64(st.ConnectionsActive))
+	}
+	header("rootproxy_listener_bytes_total", "counter", "Bytes read from (in) and written to (out) listener clients.")
+	for _, st := range stats {
+		sample("rootproxy_listener_bytes_total", []string{"kind", string(st.Kind), "direction", "in"}, float64(st.BytesIn))
+		sample("rootproxy_listener_bytes_total", []string{"kind", string(st.Kind), "direction", "out"}, float64(st.BytesOut))
+	}
+
+	header("rootproxy_start_time_seconds", "gauge", "Start time of the process since the unix epoch.")
+	sample("rootproxy_start_time_seconds", nil, float64(app.Monitor.StartedAt().Unix()))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
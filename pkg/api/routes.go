@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,12 +15,17 @@ import (
 
 	"github.com/lily0ng/RootProxy/internal/cert"
 	"github.com/lily0ng/RootProxy/internal/config"
+	"github.com/lily0ng/RootProxy/internal/forwarder"
 	"github.com/lily0ng/RootProxy/internal/proxy"
 	"github.com/lily0ng/RootProxy/internal/rootproxy"
+	"github.com/lily0ng/RootProxy/internal/vault"
 )
 
 func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
+	r.Handle("/metrics", metricsHandler(app)).Methods(http.MethodGet)
+
 	v1 := r.PathPrefix("/api/v1").Subrouter()
+	v1.Use(auditMiddleware(app))
 	writeJSON := func(w http.ResponseWriter, status int, v any) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(status)
@@ -28,6 +34,42 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 	writeErr := func(w http.ResponseWriter, status int, err error) {
 		http.Error(w, err.Error(), status)
 	}
+	// Passwords are left out of responses unless the caller asks for them
+	// with reveal=true and the vault is unlocked.
+	revealRequested := func(r *http.Request) (bool, error) {
+		if r.URL.Query().Get("reveal") != "true" {
+			return false, nil
+		}
+		if !app.Vault.Unlocked() {
+			return false, vault.ErrLocked
+		}
+		return true, nil
+	}
+	exposeProxies := func(r *http.Request, items []proxy.Proxy) ([]proxy.Proxy, error) {
+		reveal, err := revealRequested(r)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]proxy.Proxy, 0, len(items))
+		for _, p := range items {
+			if reveal {
+				if p, err = app.ResolveSecrets(p); err != nil {
+					return nil, err
+				}
+			} else {
+				p = p.Redacted()
+			}
+			out = append(out, p)
+		}
+		return out, nil
+	}
+	exposeProxy := func(r *http.Request, p proxy.Proxy) (proxy.Proxy, error) {
+		items, err := exposeProxies(r, []proxy.Proxy{p})
+		if err != nil {
+			return proxy.Proxy{}, err
+		}
+		return items[0], nil
+	}
 
 	v1.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
 		activeProxy := app.Proxies.ActiveName()
@@ -39,16 +81,26 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		})
 	}).Methods(http.MethodGet)
 
-	v1.HandleFunc("/proxy/list", func(w http.ResponseWriter, _ *http.Request) {
-		writeJSON(w, http.StatusOK, app.Proxies.List())
+	v1.HandleFunc("/proxy/list", func(w http.ResponseWriter, r *http.Request) {
+		items, err := exposeProxies(r, app.Proxies.List())
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, items)
 	}).Methods(http.MethodGet)
 
-	v1.HandleFunc("/proxy/active", func(w http.ResponseWriter, _ *http.Request) {
+	v1.HandleFunc("/proxy/active", func(w http.ResponseWriter, r *http.Request) {
 		p, ok := app.Proxies.GetActive()
 		if !ok {
 			writeJSON(w, http.StatusOK, map[string]any{"active": nil})
 			return
 		}
+		p, err := exposeProxy(r, p)
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
 		writeJSON(w, http.StatusOK, map[string]any{"active": p})
 	}).Methods(http.MethodGet)
 
@@ -73,11 +125,12 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := app.Proxies.Add(p); err != nil {
-			writeErr(w, http.StatusBadRequest, err)
+		if err := app.AddProxy(p); err != nil {
+			writeErr(w, vaultStatus(err, http.StatusBadRequest), err)
 			return
 		}
-		writeJSON(w, http.StatusCreated, p)
+		added, _ := app.Proxies.GetByName(p.Name)
+		writeJSON(w, http.StatusCreated, added.Redacted())
 	}).Methods(http.MethodPost)
 
 	v1.HandleFunc("/proxy/update/{id}", func(w http.ResponseWriter, r *http.Request) {
@@ -87,20 +140,30 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := app.Proxies.Update(id, p); err != nil {
-			writeErr(w, http.StatusBadRequest, err)
+		// Clients only ever see redacted records, so an update without a
+		// password keeps the stored one.
+		if old, ok := app.Proxies.GetByID(id); ok && p.Pass == "" && (p.User == "" || p.User == old.User) {
+			p.User, p.Pass, p.PassRef = old.User, old.Pass, old.PassRef
+		}
+		if err := app.UpdateProxy(id, p); err != nil {
+			writeErr(w, vaultStatus(err, http.StatusBadRequest), err)
 			return
 		}
 		updated, _ := app.Proxies.GetByID(id)
-		writeJSON(w, http.StatusOK, updated)
+		writeJSON(w, http.StatusOK, updated.Redacted())
 	}).Methods(http.MethodPost)
 
 	v1.HandleFunc("/proxy/remove/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
+		old, _ := app.Proxies.GetByID(id)
 		if err := app.Proxies.Remove(id); err != nil {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
+		if old.PassRef != "" {
+			// A locked vault keeps the orphaned secret; it is harmless.
+			_ = app.Vault.Delete(old.PassRef)
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}).Methods(http.MethodDelete)
 
@@ -114,25 +177,134 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 			writeErr(w, http.StatusBadRequest, errors.New("proxy not found"))
 			return
 		}
+		p, err := app.ResolveSecrets(p)
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
 		tmo := 3 * time.Second
 		if qs := r.URL.Query().Get("timeout_ms"); qs != "" {
 			if ms, err := strconv.Atoi(qs); err == nil && ms > 0 {
 				tmo = time.Duration(ms) * time.Millisecond
 			}
 		}
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			target = app.CurrentSettings().ProbeTarget
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), tmo)
 		defer cancel()
-		tr := proxy.TestConnectivity(ctx, p)
+		tr := proxy.TestConnectivity(ctx, p, proxy.TestOptions{Target: target})
 		app.Monitor.RecordTest(name, tr)
 		writeJSON(w, http.StatusOK, tr)
 	}).Methods(http.MethodPost)
 
+	v1.HandleFunc("/proxy/test_all", testAllHandler(app)).Methods(http.MethodPost)
+
+	v1.HandleFunc("/proxy/anonymity", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = app.Proxies.ActiveName()
+		}
+		p, ok := app.Proxies.GetByName(name)
+		if !ok {
+			writeErr(w, http.StatusBadRequest, errors.New("proxy not found"))
+			return
+		}
+		p, err := app.ResolveSecrets(p)
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
+		tmo := 10 * time.Second
+		if qs := r.URL.Query().Get("timeout_ms"); qs != "" {
+			if ms, err := strconv.Atoi(qs); err == nil && ms > 0 {
+				tmo = time.Duration(ms) * time.Millisecond
+			}
+		}
+		echoURL := r.URL.Query().Get("url")
+		if echoURL == "" {
+			echoURL = app.CurrentSettings().EchoURL
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), tmo)
+		defer cancel()
+		ar := proxy.CheckAnonymity(ctx, p, echoURL, r.URL.Query().Get("baseline") == "true")
+		app.Monitor.RecordAnonymity(name, ar)
+		writeJSON(w, http.StatusOK, ar)
+	}).Methods(http.MethodPost)
+
+	v1.HandleFunc("/proxy/speedtest", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		name, chainName := q.Get("name"), q.Get("chain")
+		if name != "" && chainName != "" {
+			writeErr(w, http.StatusBadRequest, errors.New("name and chain are exclusive"))
+			return
+		}
+		var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+		if chainName != "" {
+			c, ok := app.Chains.Get(chainName)
+			if !ok {
+				writeErr(w, http.StatusBadRequest, errors.New("chain not found"))
+				return
+			}
+			hops, err := app.ResolveChain(c)
+			if err != nil {
+				writeErr(w, vaultStatus(err, http.StatusBadRequest), err)
+				return
+			}
+			dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return proxy.DialHops(ctx, hops, network, addr)
+			}
+		} else {
+			if name == "" {
+				name = app.Proxies.ActiveName()
+			}
+			p, ok := app.Proxies.GetByName(name)
+			if !ok {
+				writeErr(w, http.StatusBadRequest, errors.New("proxy not found"))
+				return
+			}
+			p, err := app.ResolveSecrets(p)
+			if err != nil {
+				writeErr(w, http.StatusForbidden, err)
+				return
+			}
+			dial = proxy.DialFunc(p)
+		}
+
+		opts := proxy.SpeedTestOptions{URL: q.Get("url"), Bytes: proxy.DefaultSpeedTestBytes}
+		if opts.URL == "" {
+			opts.URL = app.CurrentSettings().SpeedTestURL
+		}
+		if qs := q.Get("bytes"); qs != "" {
+			n, err := strconv.ParseInt(qs, 10, 64)
+			if err != nil || n <= 0 {
+				writeErr(w, http.StatusBadRequest, errors.New("invalid bytes"))
+				return
+			}
+			opts.Bytes = n
+		}
+		tmo := 60 * time.Second
+		if qs := q.Get("timeout_ms"); qs != "" {
+			if ms, err := strconv.Atoi(qs); err == nil && ms > 0 {
+				tmo = time.Duration(ms) * time.Millisecond
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), tmo)
+		defer cancel()
+		writeJSON(w, http.StatusOK, proxy.SpeedTest(ctx, dial, opts))
+	}).Methods(http.MethodPost)
+
 	v1.HandleFunc("/proxy/export", func(w http.ResponseWriter, r *http.Request) {
 		format := r.URL.Query().Get("format")
 		if format == "" {
 			format = "json"
 		}
-		items := app.Proxies.List()
+		items, err := exposeProxies(r, app.Proxies.List())
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
 		switch format {
 		case "json":
 			b, err := proxy.ExportJSON(items)
@@ -194,7 +366,7 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 			if p.Auth == "" {
 				p.Auth = proxy.AuthNone
 			}
-			if err := app.Proxies.Add(p); err != nil {
+			if err := app.AddProxy(p); err != nil {
 				res.Failed = append(res.Failed, failure{Name: p.Name, Error: err.Error()})
 				continue
 			}
@@ -245,13 +417,39 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
-		if err := app.Chains.Upsert(c, 5); err != nil {
+		if err := app.Chains.Upsert(c, proxy.MaxChainHops); err != nil {
 			writeErr(w, http.StatusBadRequest, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, c)
 	}).Methods(http.MethodPost)
 
+	v1.HandleFunc("/chain/test", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeErr(w, http.StatusBadRequest, errors.New("name required"))
+			return
+		}
+		tmo := 10 * time.Second
+		if qs := r.URL.Query().Get("timeout_ms"); qs != "" {
+			if ms, err := strconv.Atoi(qs); err == nil && ms > 0 {
+				tmo = time.Duration(ms) * time.Millisecond
+			}
+		}
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			target = app.CurrentSettings().ProbeTarget
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), tmo)
+		defer cancel()
+		res, err := app.TestChain(ctx, name, target)
+		if err != nil {
+			writeErr(w, vaultStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}).Methods(http.MethodPost)
+
 	v1.HandleFunc("/chain/remove/{name}", func(w http.ResponseWriter, r *http.Request) {
 		name := mux.Vars(r)["name"]
 		if err := app.Chains.Remove(name); err != nil {
@@ -287,6 +485,40 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		w.WriteHeader(http.StatusNoContent)
 	}).Methods(http.MethodDelete)
 
+	v1.HandleFunc("/routing/resolve", func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		var ip net.IP
+		if qs := r.URL.Query().Get("ip"); qs != "" {
+			if ip = net.ParseIP(qs); ip == nil {
+				writeErr(w, http.StatusBadRequest, errors.New("invalid ip"))
+				return
+			}
+		}
+		if host == "" && ip == nil {
+			writeErr(w, http.StatusBadRequest, errors.New("host required"))
+			return
+		}
+		rt, err := app.PreviewRoute(host, ip)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		hops := make([]string, 0, len(rt.Hops))
+		for _, p := range rt.Hops {
+			hops = append(hops, p.Name)
+		}
+		res := map[string]any{
+			"host":     host,
+			"decision": rt.Decision,
+			"direct":   rt.Direct,
+			"hops":     hops,
+		}
+		if rt.Pick != "" {
+			res["pick"] = rt.Pick
+		}
+		writeJSON(w, http.StatusOK, res)
+	}).Methods(http.MethodGet)
+
 	v1.HandleFunc("/rotation/rotate", func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Profile string `json:"profile"`
@@ -297,22 +529,21 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		if body.Profile == "" {
 			body.Profile = app.Profiles.Active()
 		}
-		if body.Mode == "" {
-			body.Mode = string(config.RotationRoundRobin)
+		prof, _ := app.Profiles.Get(body.Profile)
+		policy := prof.Rotation
+		if body.Mode != "" {
+			policy.Mode = config.RotationMode(body.Mode)
 		}
-		policy := config.RotationPolicy{Enabled: body.Enabled, Mode: config.RotationMode(body.Mode)}
-		if !policy.Enabled {
-			policy.Enabled = true
+		if policy.Mode == "" || policy.Mode == config.RotationOff {
+			policy.Mode = config.RotationRoundRobin
 		}
+		policy.Enabled = true
 
-		var chain []string
-		for _, p := range app.Profiles.List() {
-			if p.Name == body.Profile {
-				chain = p.Chain
-				break
-			}
+		name, err := app.Scheduler.Rotate(body.Profile, prof.Chain, policy)
+		if errors.Is(err, proxy.ErrAllUnhealthy) {
+			writeErr(w, http.StatusServiceUnavailable, err)
+			return
 		}
-		name, err := app.Rotator.Rotate(body.Profile, chain, policy, app.Proxies)
 		if err != nil {
 			writeErr(w, http.StatusBadRequest, err)
 			return
@@ -320,6 +551,52 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		writeJSON(w, http.StatusOK, map[string]any{"active": name})
 	}).Methods(http.MethodPost)
 
+	v1.HandleFunc("/rotation/schedule", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, app.Scheduler.Status())
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/rotation/events", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, app.Scheduler.Events())
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/rotation/affinity", func(w http.ResponseWriter, _ *http.Request) {
+		items := app.Rotator.Affinities()
+		if items == nil {
+			items = []proxy.Affinity{}
+		}
+		writeJSON(w, http.StatusOK, items)
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/rotation/affinity", func(w http.ResponseWriter, r *http.Request) {
+		n := app.Rotator.ClearAffinity(r.URL.Query().Get("profile"), r.URL.Query().Get("host"))
+		writeJSON(w, http.StatusOK, map[string]any{"cleared": n})
+	}).Methods(http.MethodDelete)
+
+	setPaused := func(paused bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Profile string `json:"profile"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.Profile == "" {
+				body.Profile = app.Profiles.Active()
+			}
+			var err error
+			if paused {
+				err = app.Scheduler.Pause(body.Profile)
+			} else {
+				err = app.Scheduler.Resume(body.Profile)
+			}
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"profile": body.Profile, "paused": paused})
+		}
+	}
+	v1.HandleFunc("/rotation/pause", setPaused(true)).Methods(http.MethodPost)
+	v1.HandleFunc("/rotation/resume", setPaused(false)).Methods(http.MethodPost)
+
 	v1.HandleFunc("/cert/list", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, http.StatusOK, app.Certs.List())
 	}).Methods(http.MethodGet)
@@ -354,13 +631,90 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		if body.ValidForHours > 0 {
 			valid = time.Duration(body.ValidForHours) * time.Hour
 		}
+		// Like proxy passwords, the key is only stored once a vault exists;
+		// until then it is returned and not kept.
+		store := app.Vault.Initialized()
+		reveal := !store
+		if store {
+			var err error
+			if reveal, err = revealRequested(r); err != nil {
+				writeErr(w, http.StatusForbidden, err)
+				return
+			}
+			if !app.Vault.Unlocked() {
+				writeErr(w, http.StatusForbidden, errors.New("unlock the vault to store the private key"))
+				return
+			}
+		}
 		gen, err := cert.GenerateSelfSigned(cert.SelfSignedOptions{CommonName: body.CommonName, ValidFor: valid})
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, err)
 			return
 		}
-		_ = app.Certs.Add(body.Name, gen.CertPEM)
-		writeJSON(w, http.StatusOK, map[string]any{"name": body.Name, "cert_pem": gen.CertPEM, "key_pem": gen.KeyPEM})
+		if err := app.Certs.Add(body.Name, gen.CertPEM); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		res := map[string]any{"name": body.Name, "cert_pem": gen.CertPEM}
+		if store {
+			ref := "cert/" + body.Name
+			if err := app.Vault.Put(ref, gen.KeyPEM); err != nil {
+				writeErr(w, vaultStatus(err, http.StatusInternalServerError), err)
+				return
+			}
+			_ = app.Certs.SetKeyRef(body.Name, ref)
+			res["key_ref"] = ref
+		}
+		if reveal {
+			res["key_pem"] = gen.KeyPEM
+		}
+		writeJSON(w, http.StatusOK, res)
+	}).Methods(http.MethodPost)
+
+	v1.HandleFunc("/cert/key", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		c, ok := app.Certs.Get(name)
+		if !ok || c.KeyRef == "" {
+			writeErr(w, http.StatusNotFound, errors.New("no private key stored for certificate"))
+			return
+		}
+		key, err := app.Vault.Get(c.KeyRef)
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"name": name, "key_pem": key})
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/vault/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"initialized": app.Vault.Initialized(),
+			"unlocked":    app.Vault.Unlocked(),
+		})
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/vault/unlock", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Passphrase string `json:"passphrase"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := app.UnlockVault(body.Passphrase); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, vault.ErrBadPassphrase) {
+				status = http.StatusForbidden
+			}
+			writeErr(w, status, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"unlocked": true})
+	}).Methods(http.MethodPost)
+
+	v1.HandleFunc("/vault/lock", func(w http.ResponseWriter, _ *http.Request) {
+		app.Vault.Lock()
+		writeJSON(w, http.StatusOK, map[string]any{"unlocked": false})
 	}).Methods(http.MethodPost)
 
 	v1.HandleFunc("/security/get", func(w http.ResponseWriter, _ *http.Request) {
@@ -377,14 +731,251 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		writeJSON(w, http.StatusOK, s)
 	}).Methods(http.MethodPost)
 
+	v1.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		reveal, err := revealRequested(r)
+		if err != nil {
+			writeErr(w, http.StatusForbidden, err)
+			return
+		}
+		s := app.CurrentSettings()
+		s.Listeners.SOCKSPass = ""
+		if reveal {
+			if _, s.Listeners.SOCKSPass, err = app.SOCKSCredentials(); err != nil {
+				writeErr(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, s)
+	}).Methods(http.MethodGet)
+
+	// Changes made here are saved, unlike the command line overrides.
+	v1.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Theme          *string `json:"theme"`
+			DefaultProfile *string `json:"default_profile"`
+			ProbeTarget    *string `json:"probe_target"`
+			EchoURL        *string `json:"echo_url"`
+			SpeedTestURL   *string `json:"speedtest_url"`
+			HTTPAddr       *string `json:"http_addr"`
+			SOCKSAddr      *string `json:"socks_addr"`
+			SOCKSUser      *string `json:"socks_user"`
+			SOCKSPass      *string `json:"socks_pass"`
+			Upstream       *string `json:"upstream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		var mode config.UpstreamMode
+		var chain string
+		if body.Upstream != nil {
+			var err error
+			if mode, chain, err = config.ParseUpstream(*body.Upstream); err != nil {
+				writeErr(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		set := func(dst *string, v *string) {
+			if v != nil {
+				*dst = *v
+			}
+		}
+		err := app.UpdateSettings(func(s *config.Settings) {
+			set(&s.Theme, body.Theme)
+			set(&s.DefaultProfile, body.DefaultProfile)
+			set(&s.ProbeTarget, body.ProbeTarget)
+			set(&s.EchoURL, body.EchoURL)
+			set(&s.SpeedTestURL, body.SpeedTestURL)
+			set(&s.Listeners.HTTPAddr, body.HTTPAddr)
+			set(&s.Listeners.SOCKSAddr, body.SOCKSAddr)
+			set(&s.Listeners.SOCKSUser, body.SOCKSUser)
+			if body.SOCKSPass != nil {
+				s.Listeners.SOCKSPass, s.Listeners.SOCKSPassRef = *body.SOCKSPass, ""
+			}
+			if body.Upstream != nil {
+				s.Listeners.Upstream, s.Listeners.UpstreamChain = mode, chain
+			}
+		})
+		if err != nil {
+			writeErr(w, vaultStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodPost)
+
 	v1.HandleFunc("/monitoring/metrics", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, http.StatusOK, app.Monitor.Snapshot())
 	}).Methods(http.MethodGet)
 
+	v1.HandleFunc("/monitoring/history", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeErr(w, http.StatusBadRequest, errors.New("name required"))
+			return
+		}
+		h, ok := app.Monitor.History(name)
+		if !ok {
+			writeErr(w, http.StatusNotFound, errors.New("no test history for proxy"))
+			return
+		}
+		writeJSON(w, http.StatusOK, h)
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/monitoring/health_check", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, app.Health.Status())
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/monitoring/health_check", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Interval    *string `json:"interval"`
+			Concurrency *int    `json:"concurrency"`
+			Timeout     *string `json:"timeout"`
+			Rise        *int    `json:"rise"`
+			Fall        *int    `json:"fall"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		cfg := app.Health.Config()
+		if body.Interval != nil {
+			d, err := parseDurationParam(*body.Interval)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, err)
+				return
+			}
+			cfg.Interval = d
+		}
+		if body.Timeout != nil {
+			d, err := parseDurationParam(*body.Timeout)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, err)
+				return
+			}
+			cfg.Timeout = d
+		}
+		if body.Concurrency != nil {
+			cfg.Concurrency = *body.Concurrency
+		}
+		if body.Rise != nil {
+			cfg.Rise = *body.Rise
+		}
+		if body.Fall != nil {
+			cfg.Fall = *body.Fall
+		}
+		if err := app.SetHealthCheck(cfg); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, app.Health.Status())
+	}).Methods(http.MethodPost)
+
+	v1.HandleFunc("/monitoring/health_check/run", func(w http.ResponseWriter, r *http.Request) {
+		run, err := app.Health.Run(r.Context())
+		if errors.Is(err, rootproxy.ErrHealthCheckRunning) {
+			writeErr(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	}).Methods(http.MethodPost)
+
 	v1.HandleFunc("/monitoring/started", func(w http.ResponseWriter, _ *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{"started_at": app.Monitor.StartedAt()})
 	}).Methods(http.MethodGet)
 
+	v1.HandleFunc("/listeners", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, app.Listeners.List())
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/events", eventsHandler(app)).Methods(http.MethodGet)
+	v1.HandleFunc("/events/stream", eventStreamHandler(app)).Methods(http.MethodGet)
+
+	v1.HandleFunc("/listeners/stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, app.Listeners.Stats())
+	}).Methods(http.MethodGet)
+
+	v1.HandleFunc("/listeners/start", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Kind string `json:"kind"`
+			Addr string `json:"addr"`
+			User string `json:"user"`
+			Pass string `json:"pass"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		kind, err := forwarder.ParseKind(body.Kind)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		ls := app.CurrentSettings().Listeners
+		opts := forwarder.Options{User: body.User, Pass: body.Pass}
+		if body.Addr == "" {
+			switch kind {
+			case forwarder.KindHTTP:
+				body.Addr = ls.HTTPAddr
+			case forwarder.KindSOCKS5:
+				body.Addr = ls.SOCKSAddr
+			}
+		}
+		if kind == forwarder.KindSOCKS5 && opts.User == "" {
+			if opts.User, opts.Pass, err = app.SOCKSCredentials(); err != nil {
+				writeErr(w, http.StatusForbidden, err)
+				return
+			}
+		}
+		// the password is saved below, which needs the vault if there is one
+		if opts.Pass != "" && app.Vault.Initialized() && !app.Vault.Unlocked() {
+			writeErr(w, http.StatusForbidden, vault.ErrLocked)
+			return
+		}
+		if err := app.Listeners.Start(kind, body.Addr, opts); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		// the listener comes back on the next start
+		err = app.UpdateSettings(func(s *config.Settings) {
+			switch kind {
+			case forwarder.KindHTTP:
+				s.Listeners.HTTPAddr = body.Addr
+			case forwarder.KindSOCKS5:
+				s.Listeners.SOCKSAddr = body.Addr
+				s.Listeners.SOCKSUser, s.Listeners.SOCKSPass = opts.User, opts.Pass
+			}
+		})
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, app.Listeners.List())
+	}).Methods(http.MethodPost)
+
+	v1.HandleFunc("/listeners/stop", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		kind, err := forwarder.ParseKind(body.Kind)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := app.Listeners.Stop(kind); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, app.Listeners.List())
+	}).Methods(http.MethodPost)
+
 	v1.HandleFunc("/integrations/burp/env", func(w http.ResponseWriter, _ *http.Request) {
 		p, ok := app.Proxies.GetActive()
 		if !ok {
@@ -430,3 +1021,19 @@ func RegisterRoutes(r *mux.Router, app *rootproxy.App) {
 		_, _ = w.Write(b.Bytes())
 	}).Methods(http.MethodGet)
 }
+
+// parseDurationParam accepts "30s"-style durations, "0" or "" for zero.
+func parseDurationParam(s string) (time.Duration, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// vaultStatus answers a locked vault with 403 and other errors with status.
+func vaultStatus(err error, status int) int {
+	if errors.Is(err, vault.ErrLocked) {
+		return http.StatusForbidden
+	}
+	return status
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lily0ng/RootProxy/internal/proxy"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+func TestGenerateSelfSignedVaultPolicy(t *testing.T) {
+	app := rootproxy.NewApp()
+	srv, err := NewServer("127.0.0.1:0", app, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a vault the key is handed out and not kept
+	rec := call(srv, "POST", "/api/v1/cert/generate_self_signed", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var res map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if res["key_pem"] == nil || res["key_ref"] != nil {
+		t.Errorf("response = %v, want the key and no reference", res)
+	}
+	if c, _ := app.Certs.Get("RootProxy"); c.KeyRef != "" {
+		t.Errorf("key ref %q stored without a vault", c.KeyRef)
+	}
+
+	if err := app.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	app.Vault.Lock()
+	if rec := call(srv, "POST", "/api/v1/cert/generate_self_signed", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("locked vault: status = %d", rec.Code)
+	}
+
+	if err := app.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	rec = call(srv, "POST", "/api/v1/cert/generate_self_signed", "")
+	res = nil
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Code != http.StatusOK || res["key_ref"] != "cert/RootProxy" || res["key_pem"] != nil {
+		t.Errorf("unlocked vault: %d %v", rec.Code, res)
+	}
+}
+
+func TestLockedVaultForbidsDialing(t *testing.T) {
+	app := rootproxy.NewApp()
+	srv, err := NewServer("127.0.0.1:0", app, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.UnlockVault("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	p := proxy.Proxy{Name: "p1", Type: proxy.TypeSOCKS5, Host: "127.0.0.1", Port: 1080, Auth: proxy.AuthBasic, User: "u", Pass: "secret"}
+	if err := app.AddProxy(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Chains.Upsert(proxy.Chain{Name: "c1", Hops: []string{"p1"}}, 8); err != nil {
+		t.Fatal(err)
+	}
+	app.Vault.Lock()
+
+	for _, target := range []string{
+		"/api/v1/proxy/speedtest?name=p1",
+		"/api/v1/proxy/speedtest?chain=c1",
+		"/api/v1/chain/test?name=c1",
+	} {
+		if rec := call(srv, "POST", target, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d: %s", target, rec.Code, rec.Body)
+		}
+	}
+}
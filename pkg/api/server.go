@@ -2,35 +2,178 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/lily0ng/RootProxy/internal/events"
 	"github.com/lily0ng/RootProxy/internal/rootproxy"
 )
 
 type Server struct {
-	addr string
-	app  *rootproxy.App
-	http *http.Server
+	network string
+	addr    string
+	mode    os.FileMode
+	app     *rootproxy.App
+	http    *http.Server
 }
 
-func NewServer(addr string, app *rootproxy.App) *Server {
+type Options struct {
+	// TLS serves HTTPS when set. Requiring verified client certificates
+	// counts as authentication for the loopback rule below.
+	TLS *tls.Config
+	// SocketMode is the file mode of a Unix socket; 0600 if zero.
+	SocketMode os.FileMode
+}
+
+// NewServer listens on a TCP address, or on a Unix socket given as
+// unix:///path (unix:// alone uses DefaultSocketPath). It refuses to serve
+// a non-loopback address while no API token exists, unless clients must
+// present certificates. Such a server requires a token on every request,
+// even if the last token is later revoked.
+func NewServer(addr string, app *rootproxy.App, opts Options) (*Server, error) {
+	s := &Server{network: "tcp", addr: addr, mode: opts.SocketMode, app: app}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if path == "" {
+			path = DefaultSocketPath()
+		}
+		s.network, s.addr = "unix", path
+		if s.mode == 0 {
+			s.mode = 0o600
+		}
+	}
+	// a socket is reachable only through the file system
+	local := s.network == "unix" || isLoopback(addr) ||
+		opts.TLS != nil && opts.TLS.ClientAuth == tls.RequireAndVerifyClientCert
+	if !local && !app.Tokens.Enabled() {
+		return nil, fmt.Errorf("refusing to serve the API on %s without authentication; create a token with `rootproxy token create` or listen on a loopback address", addr)
+	}
 	r := mux.NewRouter()
-	s := &Server{addr: addr, app: app}
+	r.Use(authMiddleware(app, !local))
 	RegisterRoutes(r, app)
 	s.http = &http.Server{
-		Addr:              addr,
 		Handler:           r,
 		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         opts.TLS,
+		ErrorLog:          log.New(eventLog{app.Events}, "", 0),
 	}
-	return s
+	return s, nil
+}
+
+// DefaultSocketPath is where the API socket lives unless another path is
+// given, and where clients look for it: rootproxy.sock in
+// $XDG_RUNTIME_DIR, or else in a private rootproxy-<uid> directory under
+// the temp directory, which the server creates with mode 0700.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "rootproxy.sock")
+	}
+	return filepath.Join(fallbackSocketDir(), "api.sock")
+}
+
+func fallbackSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("rootproxy-%d", os.Getuid()))
+}
+
+// privateDir creates dir with mode 0700, or checks that an existing one is
+// a directory only we can use, so nobody else can plant a socket in it.
+func privateDir(dir string) error {
+	err := os.Mkdir(dir, 0o700)
+	if err == nil || !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() || fi.Mode().Perm()&0o077 != 0 || !ownedBySelf(fi) {
+		return fmt.Errorf("%s: not a private directory owned by the current user", dir)
+	}
+	return nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if s.network != "unix" {
+		return net.Listen("tcp", s.addr)
+	}
+	dir := filepath.Dir(s.addr)
+	if dir == fallbackSocketDir() {
+		if err := privateDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	// a socket left behind by a process that died is replaced; one with a
+	// live server behind it is not
+	if fi, err := os.Lstat(s.addr); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s: exists and is not a socket", s.addr)
+		}
+		if c, err := net.DialTimeout("unix", s.addr, time.Second); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s: address already in use", s.addr)
+		}
+		_ = os.Remove(s.addr)
+	}
+
+	// the socket is created in a private directory, given its mode and only
+	// then moved into place, so it is never reachable with umask permissions
+	tmp, err := os.MkdirTemp(dir, ".rootproxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	staged := filepath.Join(tmp, "api.sock")
+	ln, err := net.Listen("unix", staged)
+	if err != nil {
+		return nil, err
+	}
+	ul := ln.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if err := os.Chmod(staged, s.mode); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	if err := os.Rename(staged, s.addr); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	return socketListener{ul, s.addr}, nil
+}
+
+// socketListener removes the socket file it was moved to when closed.
+type socketListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l socketListener) Close() error {
+	err := l.UnixListener.Close()
+	_ = os.Remove(l.path)
+	return err
 }
 
 func (s *Server) Start(ctx context.Context) error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
 	errCh := make(chan error, 1)
-	go func() { errCh <- s.http.ListenAndServe() }()
+	go func() {
+		if s.http.TLSConfig != nil {
+			errCh <- s.http.ServeTLS(ln, "", "")
+			return
+		}
+		errCh <- s.http.Serve(ln)
+	}()
 
 	select {
 	case <-ctx.Done():
@@ -45,3 +188,13 @@ func (s *Server) Start(ctx context.Context) error {
 		return err
 	}
 }
+
+// eventLog sends the server's own errors, such as failed TLS handshakes, to
+// the event log instead of stderr, where they would garble the TUI.
+type eventLog struct{ bus *events.Bus }
+
+func (l eventLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(strings.TrimPrefix(string(p), "http: "))
+	l.bus.Publish(events.LevelWarn, events.TopicAPI, msg, nil)
+	return len(p), nil
+}
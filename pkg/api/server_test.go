@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+func startSocketServer(t *testing.T, addr string, opts Options) *Server {
+	t.Helper()
+	srv, err := NewServer(addr, rootproxy.NewApp(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := srv.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.http.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.http.Close() })
+	return srv
+}
+
+func TestUnixSocketServer(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "rootproxy.sock")
+
+	// a socket left behind by a dead process is replaced
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	startSocketServer(t, "unix://"+sock, Options{SocketMode: 0o660})
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode = %o, want 660", fi.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("staging directory left behind: %v", entries)
+	}
+
+	resp, err := NewClient("unix://"+sock, "rp_token").Do(context.Background(), "GET", "/api/v1/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+
+	second, _ := NewServer("unix://"+sock, rootproxy.NewApp(), Options{})
+	if _, err := second.listen(); err == nil {
+		t.Error("second server took over a live socket")
+	}
+}
+
+func TestUnixSocketRefusesOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := NewServer("unix://"+path, rootproxy.NewApp(), Options{})
+	if _, err := srv.listen(); err == nil {
+		t.Fatal("listened over a regular file")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "keep" {
+		t.Error("regular file replaced")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := NewClient("unix://"+path, "rp_token").Do(ctx, "GET", "/api/v1/status", nil); err == nil {
+		t.Error("client sent credentials to a regular file")
+	}
+}
+
+func TestDefaultSocketPathFallback(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", t.TempDir())
+	path := DefaultSocketPath()
+	startSocketServer(t, "unix://", Options{})
+
+	fi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o700 {
+		t.Errorf("socket directory mode = %o, want 700", fi.Mode().Perm())
+	}
+
+	// a directory others can write to is not trusted
+	if err := os.Chmod(filepath.Dir(path), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(filepath.Dir(path)); err == nil {
+		t.Error("world-writable socket directory accepted")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package api
+
+import "os"
+
+// ownedBySelf cannot check ownership here; such systems rely on the
+// permissions of the socket's directory.
+func ownedBySelf(os.FileInfo) bool { return true }
@@ -0,0 +1,17 @@
+//go:build unix
+
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedBySelf reports whether fi belongs to the current user or to root.
+func ownedBySelf(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return int(st.Uid) == os.Getuid() || st.Uid == 0
+}
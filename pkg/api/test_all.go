@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lily0ng/RootProxy/internal/proxy"
+	"github.com/lily0ng/RootProxy/internal/rootproxy"
+)
+
+type testAllResult struct {
+	Name   string           `json:"name"`
+	Type   proxy.Type       `json:"type"`
+	Result proxy.TestResult `json:"result"`
+}
+
+type testAllSummary struct {
+	Done   bool `json:"done"`
+	Tested int  `json:"tested"`
+	Passed int  `json:"passed"`
+	Failed int  `json:"failed"`
+}
+
+// testAllHandler tests every proxy matching the profile, chain and type
+// filters on a worker pool and streams each result as it completes, as
+// NDJSON (default) or Server-Sent Events (format=sse or an Accept header of
+// text/event-stream). The last record is a summary.
+func testAllHandler(app *rootproxy.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		items, err := filterProxies(app, q.Get("profile"), q.Get("chain"), q.Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := proxy.PoolOptions{
+			Test:    proxy.TestOptions{Target: app.CurrentSettings().ProbeTarget},
+			Prepare: app.ResolveSecrets,
+		}
+		if qs := q.Get("concurrency"); qs != "" {
+			n, err := strconv.Atoi(qs)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid concurrency", http.StatusBadRequest)
+				return
+			}
+			opts.Concurrency = n
+		}
+		if qs := q.Get("timeout_ms"); qs != "" {
+			if ms, err := strconv.Atoi(qs); err == nil && ms > 0 {
+				opts.Timeout = time.Duration(ms) * time.Millisecond
+			}
+		}
+		if target := q.Get("target"); target != "" {
+			opts.Test.Target = target
+		}
+
+		sse := q.Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		switch {
+		case sse:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		case q.Get("format") == "" || q.Get("format") == "ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		default:
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		emit := func(event string, v any) {
+			b, _ := json.Marshal(v)
+			if sse {
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+			} else {
+				_, _ = w.Write(append(b, '\n'))
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		sum := testAllSummary{Done: true}
+		proxy.TestMany(r.Context(), items, opts, func(p proxy.Proxy, tr proxy.TestResult) {
+			app.Monitor.RecordTest(p.Name, tr)
+			sum.Tested++
+			if tr.OK {
+				sum.Passed++
+			} else {
+				sum.Failed++
+			}
+			emit("result", testAllResult{Name: p.Name, Type: p.Type, Result: tr})
+		})
+		emit("done", sum)
+	}
+}
+
+func filterProxies(app *rootproxy.App, profile, chain, typ string) ([]proxy.Proxy, error) {
+	items := app.Proxies.List()
+	keep := func(names []string) {
+		allowed := make(map[string]bool, len(names))
+		for _, n := range names {
+			allowed[n] = true
+		}
+		out := items[:0]
+		for _, p := range items {
+			if allowed[p.Name] {
+				out = append(out, p)
+			}
+		}
+		items = out
+	}
+
+	if profile != "" {
+		prof, ok := app.Profiles.Get(profile)
+		if !ok {
+			return nil, errors.New("profile not found")
+		}
+		keep(prof.Chain)
+	}
+	if chain != "" {
+		c, ok := app.Chains.Get(chain)
+		if !ok {
+			return nil, errors.New("chain not found")
+		}
+		hops, err := app.Dialer.Resolve(c)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(hops))
+		for _, h := range hops {
+			names = append(names, h.Name)
+		}
+		keep(names)
+	}
+	if typ != "" {
+		out := items[:0]
+		for _, p := range items {
+			if string(p.Type) == typ {
+				out = append(out, p)
+			}
+		}
+		items = out
+	}
+	return items, nil
+}